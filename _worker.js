@@ -17,6 +17,8 @@ export default {
 	  
 	  if (path === "ws") {
 		return handleWSRequest(request);
+	  } else if (path === "up" || path === "__up") {
+		return handleUploadRequest(request);
 	  } else if (path === "locations") {
 		let targetUrl = `http${isSecure ? 's' : ''}://speed.cloudflare.com/locations`;
 		let cfRequest = new Request(targetUrl, request);
@@ -66,6 +68,28 @@ export default {
 	  return response;
   }
   
+  async function handleUploadRequest(request) {
+	if (request.method !== "POST") {
+	  return new Response("Expected POST", { status: 405 });
+	}
+  
+	// 读取并丢弃上传的数据，返回接收到的字节数
+	let received = 0;
+	if (request.body) {
+	  const reader = request.body.getReader();
+	  while (true) {
+		const { done, value } = await reader.read();
+		if (done) {
+		  break;
+		}
+		received += value.byteLength;
+	  }
+	}
+	return new Response(JSON.stringify({ bytes: received }), {
+	  headers: { "Content-Type": "application/json" },
+	});
+  }
+  
   async function handleWSRequest(request) {
 	const upgradeHeader = request.headers.get('Upgrade');
 	if (!upgradeHeader || upgradeHeader !== 'websocket') {
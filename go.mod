@@ -2,7 +2,11 @@ module github.com/jackrun123/cfiptest
 
 go 1.22.1
 
-require github.com/PuerkitoBio/goquery v1.9.1
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/PuerkitoBio/goquery v1.9.1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/francoispqt/gojay v1.2.13 // indirect
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	asn2 "github.com/jackrun123/cfiptest/pkgs/asn"
+	"github.com/jackrun123/cfiptest/pkgs/server"
 	"github.com/jackrun123/cfiptest/pkgs/speed"
 	"math/rand"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -19,34 +24,97 @@ var (
 	st           = speed.CFSpeedTest{}
 	asn          = asn2.ASN{}
 	asnCmd       *flag.FlagSet
+	scanASN      = asn2.ASN{}
+	scanCmd      *flag.FlagSet
+	srv          = server.Server{}
+	serveCmd     *flag.FlagSet
+	locationHost string
+	locationsCmd *flag.FlagSet
 	debugAddress string
+	configFile   string
+	profile      string
 )
 
 func init() {
 	rand.Seed(time.Now().Unix())
-	flag.StringVar(&st.IpFile, "f", "ip.txt", "IP地址文件名称，格式1.0.0.127,443")
+	flag.StringVar(&st.IpFile, "f", "ip.txt", "IP地址文件名称，格式1.0.0.127,443，为-时从标准输入读取")
+	flag.StringVar(&st.ExcludeFile, "exclude", "", "不测试的IP段文件，每行一个IP或CIDR，支持IPv4和IPv6")
 	flag.StringVar(&st.OutFile, "o", "ip.csv", "输出文件名称")
+	flag.StringVar(&st.OutputFormat, "format", "csv", "输出格式，csv、json或ndjson，ndjson会在每个IP测试完成时立即写入")
 	flag.IntVar(&st.DefaultPort, "p", 443, "默认端口")
 	flag.IntVar(&st.MaxThread, "dt", 100, "并发请求最大协程数")
 	flag.IntVar(&st.SpeedTestTimeout, "sto", 5, "速度测试超时时间")
 	flag.IntVar(&st.SpeedTestThread, "st", 1, "下载测速协程数量,设为0禁用测速")
 	flag.StringVar(&st.SpeedTestURL, "url", "speed.cloudflare.com/__down?bytes=100000000", "测速文件地址")
 	flag.StringVar(&st.DelayTestURL, "delay_url", "www.visa.com.hk", "延迟测试地址，要求是使用cloudflare的地址，只用填域名")
-	flag.IntVar(&st.DelayTestType, "dtt", 0, "延迟测试类型, 0: http测试 1：tcp测试")
+	flag.IntVar(&st.DelayTestType, "dtt", 0, "延迟测试类型, 0: http测试 1：tcp测试 2：http3(quic)测试")
+	flag.IntVar(&st.QuicPort, "qp", 0, "http3测试使用的UDP端口，0表示与IP的端口相同")
+	flag.IntVar(&st.DelayTestCount, "dc", 1, "每个IP延迟测试次数，多次测试时统计最小、平均、中位数、95分位延迟、抖动和丢包率")
+	flag.StringVar(&st.SortBy, "sort", "", "结果排序字段，可选delay(平均延迟)、min、median、p95、jitter、loss、speed(平均速度)、peak(峰值速度)、sustained(持续速度)、stable(持续速度×稳定性)、parallel(多连接总速度)、upload、score(综合评分)，默认开启测速时按stable排序，否则按delay排序")
+	flag.StringVar(&st.DownloadProtocol, "dp", "h1", "测速协议，h1: HTTP/1.1 h3: HTTP/3(quic)")
+	flag.IntVar(&st.ParallelConns, "pc", 0, "多连接并发测速的连接数，大于1时在单连接测速后额外测试同一IP的多连接总速度")
+	flag.StringVar(&st.UploadTestURL, "uurl", "", "上传测速地址，例如speed.cloudflare.com/__up，为空时不测试上传")
+	flag.IntVar(&st.UploadSize, "usize", 10, "上传测速的数据大小(MB)")
+	flag.IntVar(&st.SampleInterval, "si", 250, "下载测速的吞吐量采样间隔(毫秒)，用于计算峰值速度、持续速度和稳定性")
+	flag.Float64Var(&st.WeightLatency, "wl", 1, "综合评分中延迟的权重")
+	flag.Float64Var(&st.WeightSpeed, "ws", 1, "综合评分中速度的权重")
+	flag.Float64Var(&st.WeightLoss, "wloss", 1, "综合评分中丢包率的权重")
+	flag.Float64Var(&st.WeightJitter, "wj", 0.5, "综合评分中抖动的权重")
+	flag.Float64Var(&st.WeightColo, "wc", 0, "综合评分中优选数据中心的权重")
+	flag.StringVar(&st.PreferIATA, "prefer", "", "优选数据中心，多个用英文逗号分隔，越靠前综合评分越高，例如：HKG,NRT")
 	flag.IntVar(&st.MaxSpeedTestCount, "maxsc", 10, "速度测试，最多测试多少个IP")
 	flag.IntVar(&st.MaxDelayCount, "maxdc", 0, "延迟测试，最多测试多少个IP，如果不限制则设置为0")
 	flag.Float64Var(&st.MinSpeed, "mins", 1, "最低速度")
-	flag.BoolVar(&st.EnableTLS, "tls", true, "是否启用TLS")
+	flag.BoolVar(&st.EnableTLS, "tls", true, "是否启用TLS，HTTP/3延迟测试和测速总是使用TLS")
 	flag.BoolVar(&st.Shuffle, "s", false, "是否打乱顺序测速")
+	flag.StringVar(&st.StateFile, "state", "", "进度文件，定期保存已完成的IP和测试结果，测试完成后自动删除，为空时不保存进度")
+	flag.BoolVar(&st.Resume, "resume", false, "从-state指定的进度文件继续上次中断的测试，IP文件和抽样、打乱顺序参数需要与上次相同")
+	flag.Int64Var(&st.Seed, "seed", 0, "抽样和打乱顺序使用的随机种子，0表示随机生成，继续测试时使用进度文件中的种子")
+	flag.IntVar(&st.SampleCount, "sample", 0, "CIDR抽样测试，每个子网随机测试多少个IP，0表示测试全部IP，可在IP文件中用sample=N单独指定")
+	flag.IntVar(&st.SamplePrefix, "sample_prefix", 24, "IPv4抽样子网的前缀长度，可在IP文件中用prefix=N单独指定")
+	flag.IntVar(&st.SamplePrefix6, "sample_prefix6", 48, "IPv6抽样子网的前缀长度，可在IP文件中用prefix=N单独指定")
+	flag.IntVar(&st.NeighbourRounds, "neighbour_rounds", 0, "邻近搜索轮数，测速完成后在优选IP所在子网中继续搜索，0表示不搜索")
+	flag.IntVar(&st.NeighbourCount, "neighbour_count", 10, "邻近搜索每轮每个子网测试多少个IP")
+	flag.IntVar(&st.NeighbourPrefix, "neighbour_prefix", 24, "IPv4邻近搜索子网的前缀长度")
+	flag.IntVar(&st.NeighbourPrefix6, "neighbour_prefix6", 120, "IPv6邻近搜索子网的前缀长度")
 	flag.StringVar(&st.FilterIATA, "iata", "", "使用IATA过滤，多个用英文逗号分隔，例如：HKG,SIN")
+	flag.StringVar(&st.ExcludeIATA, "exclude_iata", "", "排除的数据中心，多个用英文逗号分隔，例如：LAX,SJC")
+	flag.IntVar(&st.ColoQuota, "colo_quota", 0, "每个数据中心最多保留多少个IP，0表示不限制")
+	flag.StringVar(&st.FilterCountry, "country", "", "使用数据中心所在的国家代码过滤，多个用英文逗号分隔，例如：JP,KR")
+	flag.StringVar(&st.FilterRegion, "region", "", "使用数据中心所在的地区过滤，多个用英文逗号分隔，例如：Asia Pacific")
+	flag.StringVar(&st.Near, "near", "", "只保留距离指定位置一定范围内的数据中心，并在结果中输出距离，格式为纬度,经度:半径km或IATA:半径km，例如：35.68,139.76:1000或HKG:2000，省略半径时只输出距离")
 	flag.BoolVar(&st.TestWebSocket, "w", false, "是否验证websocket，如果要验证，delay_url需要支持websocket，客户端会请求xx.com/ws地址")
 	flag.BoolVar(&st.VerboseMode, "vv", false, "详细日志模式，打印出错信息")
 	flag.BoolVar(&printVersion, "v", false, "打印程序版本")
 	flag.BoolVar(&isShowHelp, "h", false, "帮助")
 	flag.StringVar(&debugAddress, "debug", "127.0.0.1:34561", "pprof调试监听地址")
+	flag.StringVar(&configFile, "config", "", "YAML或TOML配置文件，命令行参数和环境变量优先于配置文件")
+	flag.StringVar(&profile, "profile", "", "使用配置文件profiles中的命名配置，例如mobile")
 
 	asnCmd = flag.NewFlagSet("asn", flag.ExitOnError)
-	asnCmd.StringVar(&asn.AsCode, "as", "", "ASN号码，例如13335")
+	asnCmd.StringVar(&asn.AsCode, "as", "", "ASN号码，多个用英文逗号分隔，例如13335,209242")
+	asnCmd.BoolVar(&asn.IPv6, "6", false, "同时获取IPv6的IP段")
+	asnCmd.StringVar(&asn.Output, "o", "", "输出文件，可以直接作为-f的IP文件，为空时输出到标准输出")
+	asnCmd.IntVar(&asn.Port, "p", 0, "在每个IP段后面加上端口，0表示不加")
+	asnCmd.StringVar(&asn.Source, "source", asn2.DefaultSource, "IP段数据源，多个用英文逗号分隔，前一个失败时使用下一个，可选：he、ripestat、delegated:文件或地址、file:文件")
+
+	// scan的参数在解析时加入主命令的参数中，这里只用于打印帮助
+	scanCmd = flag.NewFlagSet("scan", flag.ExitOnError)
+	scanCmd.StringVar(&scanASN.AsCode, "asn", "", "ASN号码，多个用英文逗号分隔，例如13335,209242")
+	scanCmd.BoolVar(&scanASN.IPv6, "6", false, "同时测试IPv6的IP段")
+	scanCmd.StringVar(&scanASN.Source, "source", asn2.DefaultSource, "IP段数据源，与asn子命令相同")
+
+	serveCmd = flag.NewFlagSet("serve", flag.ExitOnError)
+	serveCmd.StringVar(&srv.Addr, "addr", ":8080", "监听地址")
+	serveCmd.StringVar(&srv.CertFile, "cert", "", "TLS证书文件，为空时使用HTTP")
+	serveCmd.StringVar(&srv.KeyFile, "key", "", "TLS私钥文件")
+	serveCmd.BoolVar(&srv.HTTP3, "h3", false, "同时在相同端口的UDP上提供HTTP/3，需要TLS证书")
+	serveCmd.StringVar(&srv.Colo, "colo", "", "/cdn-cgi/trace和Cf-Meta-Colo响应头返回的数据中心，用于本地测试")
+	serveCmd.Int64Var(&srv.Bandwidth, "bw", 0, "下载和上传限速(字节/秒)，0表示不限速")
+	serveCmd.StringVar(&srv.LocationsURL, "locations", "https://speed.cloudflare.com/locations", "/locations转发的地址")
+
+	locationsCmd = flag.NewFlagSet("locations update", flag.ExitOnError)
+	locationsCmd.StringVar(&locationHost, "host", "speed.cloudflare.com", "获取位置信息的地址，会请求该地址的/locations，可以使用自己部署的_worker.js或cfiptest serve的域名")
 }
 
 func main() {
@@ -62,32 +130,159 @@ func main() {
 		}()
 	}
 
+	flag.Usage = usage
 	switch cmd {
 	case "asn":
 		asnCmd.Parse(os.Args[2:])
-		asn.Run()
+		if err := asn.Run(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case "locations":
+		if len(os.Args) < 3 || os.Args[2] != "update" {
+			fmt.Println("例子：cfiptest locations update")
+			locationsCmd.PrintDefaults()
+			os.Exit(1)
+		}
+		locationsCmd.Parse(os.Args[3:])
+		updateLocations()
+	case "scan":
+		scanCmd.VisitAll(func(f *flag.Flag) {
+			flag.CommandLine.Var(f.Value, f.Name, f.Usage)
+		})
+		flag.CommandLine.Parse(os.Args[2:])
+		runTest(true)
+	case "serve":
+		serveCmd.Parse(os.Args[2:])
+		if err := srv.Run(); err != nil {
+			fmt.Printf("测速服务启动失败: %v\n", err)
+			os.Exit(1)
+		}
 	default:
-		flag.Usage = func() {
-			fmt.Println("使用方法：")
-			fmt.Println("例子：cfiptest -f ./ip.txt -url speed.cloudflare.com/__down?bytes=100000000")
-			fmt.Println("参数：")
-			flag.PrintDefaults()
-			fmt.Println()
-			fmt.Println("cfiptest asn 用于根据asn获取ip段")
-			fmt.Println("例子：cfiptest asn -as 13335")
-			asnCmd.PrintDefaults()
+		flag.Parse()
+		runTest(false)
+	}
+}
 
+func usage() {
+	fmt.Println("使用方法：")
+	fmt.Println("例子：cfiptest -f ./ip.txt -url speed.cloudflare.com/__down?bytes=100000000")
+	fmt.Println("参数：")
+	flag.PrintDefaults()
+	fmt.Println()
+	fmt.Println("cfiptest asn 用于根据asn获取ip段")
+	fmt.Println("例子：cfiptest asn -as 13335,209242 -6 -o ip.txt -p 443")
+	asnCmd.PrintDefaults()
+	fmt.Println()
+	fmt.Println("cfiptest scan 获取asn的ip段后直接测试，支持上面的全部参数，没有指定-sample时每个子网抽样1个IP")
+	fmt.Println("例子：cfiptest scan -asn 13335 -p 443 -sample 2")
+	scanCmd.PrintDefaults()
+	fmt.Println()
+	fmt.Println("cfiptest locations update 从测速地址的/locations更新数据中心位置信息并缓存到本地")
+	fmt.Println("例子：cfiptest locations update -host speed.cloudflare.com")
+	locationsCmd.PrintDefaults()
+	fmt.Println()
+	fmt.Println("cfiptest serve 启动测速源站，代替_worker.js部署在自己的服务器上")
+	fmt.Println("例子：cfiptest serve -addr :443 -cert cert.pem -key key.pem")
+	serveCmd.PrintDefaults()
+}
+
+// runTest 运行测试，scan为true时测试scanASN获取的IP段
+func runTest(scan bool) {
+	if printVersion {
+		println(version)
+		os.Exit(0)
+	}
+
+	if isShowHelp {
+		flag.Usage()
+		os.Exit(0)
+	}
+	if err := loadConfig(); err != nil {
+		fmt.Printf("读取配置文件失败: %v\n", err)
+		os.Exit(1)
+	}
+	// Ctrl-C时取消测试并输出已完成的结果，再按一次Ctrl-C直接退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if scan {
+		if err := scanPrefixes(ctx); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-		flag.Parse()
-		if printVersion {
-			println(version)
-			os.Exit(0)
+	}
+	st.Version = version
+	st.Run(ctx)
+}
+
+// scanPrefixes 获取ASN的IP段作为测试的IP，没有指定抽样时每个子网抽样1个IP
+func scanPrefixes(ctx context.Context) error {
+	if scanASN.AsCode == "" {
+		return fmt.Errorf("cfiptest scan 需要用-asn指定ASN号码")
+	}
+	prefixes, err := scanASN.Prefixes(ctx)
+	if err != nil {
+		return err
+	}
+	st.Prefixes = prefixes
+	if st.SampleCount == 0 && !isFlagSet("sample") {
+		st.SampleCount = 1
+	}
+	fmt.Printf("已获取AS%s的%d个IP段\n", scanASN.AsCode, len(prefixes))
+	return nil
+}
+
+// isFlagSet 判断命令行中是否指定了参数
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
 		}
+	})
+	return set
+}
 
-		if isShowHelp {
-			flag.Usage()
-			os.Exit(0)
+// loadConfig 读取配置文件，命令行中指定的参数覆盖配置文件
+func loadConfig() error {
+	if configFile == "" {
+		if profile != "" {
+			return fmt.Errorf("使用-profile时需要用-config指定配置文件")
 		}
-		st.Run()
+		return nil
+	}
+
+	explicit := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+	if err := st.LoadConfig(configFile, profile); err != nil {
+		return err
+	}
+	for name, value := range explicit {
+		flag.Set(name, value)
+	}
+	return nil
+}
+
+// updateLocations 获取最新的位置信息并写入本地缓存
+func updateLocations() {
+	url := locationHost
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "https://" + url
+	}
+	url = strings.TrimSuffix(url, "/") + "/locations"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cache, err := speed.UpdateLocations(ctx, url)
+	if err != nil {
+		fmt.Printf("更新位置信息失败: %v\n", err)
+		os.Exit(1)
 	}
+	path, _ := speed.LocationCachePath()
+	fmt.Printf("已从 %s 获取%d个数据中心的位置信息，缓存到 %s\n", url, len(cache.Locations), path)
 }
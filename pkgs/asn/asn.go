@@ -1,35 +1,113 @@
 package asn
 
 import (
+	"bufio"
+	"context"
 	"fmt"
-	"github.com/PuerkitoBio/goquery"
-	"log"
-	"net/http"
+	"io"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jackrun123/cfiptest/pkgs/prefixset"
 )
 
 type ASN struct {
-	AsCode string
+	AsCode string // ASN号码，多个用英文逗号分隔
+	IPv6   bool   // 是否同时获取IPv6的IP段
+	Output string // 输出文件，为空时输出到标准输出
+	Port   int    // 输出时在每个IP段后面加上端口，0表示不加
+	Source string // 数据源，多个用英文逗号分隔，为空时使用DefaultSource
 }
 
-func (a *ASN) Run() {
-	if a.AsCode == "" {
-		fmt.Println("-as 参数不正确")
-		os.Exit(1)
+// Run 获取IP段并输出到Output或标准输出
+func (a *ASN) Run() error {
+	prefixes, err := a.Prefixes(context.Background())
+	if err != nil {
+		return err
 	}
-	resp, err := http.Get("https://bgp.he.net/" + "AS" + a.AsCode)
+
+	if a.Output == "" {
+		return a.write(os.Stdout, prefixes)
+	}
+	file, err := os.Create(a.Output)
 	if err != nil {
-		fmt.Printf("请求失败，err=%s\n", err)
-		return
+		return err
+	}
+	if err := a.write(file, prefixes); err != nil {
+		file.Close()
+		return err
 	}
-	defer resp.Body.Close()
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("已写入%d个IP段到 %s\n", len(prefixes), a.Output)
+	return nil
+}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+// Prefixes 从数据源获取全部ASN的IP段，合并重叠和相邻的IP段后按地址排序，IPv6为false时只返回IPv4
+func (a *ASN) Prefixes(ctx context.Context) ([]netip.Prefix, error) {
+	codes, err := parseASNs(a.AsCode)
+	if err != nil {
+		return nil, err
+	}
+	source := a.Source
+	if source == "" {
+		source = DefaultSource
+	}
+	sources, err := ParseSources(source)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	// 多个ASN或数据源返回的IP段可能重叠，合并后输出
+	set := &prefixset.Set{}
+	for _, code := range codes {
+		list, err := fetchPrefixes(ctx, sources, code)
+		if err != nil {
+			return nil, fmt.Errorf("获取AS%s的IP段失败: %w", code, err)
+		}
+		for _, prefix := range list {
+			if prefix.Addr().Is4() || a.IPv6 {
+				set.Add(prefix)
+			}
+		}
+	}
+	return set.Prefixes(), nil
+}
+
+// parseASNs 解析英文逗号分隔的ASN号码，号码前面可以带AS
+func parseASNs(value string) ([]string, error) {
+	var codes []string
+	for _, code := range strings.Split(value, ",") {
+		code = strings.TrimSpace(code)
+		if code == "" {
+			continue
+		}
+		if len(code) > 2 && strings.EqualFold(code[:2], "AS") {
+			code = code[2:]
+		}
+		if _, err := strconv.ParseUint(code, 10, 32); err != nil {
+			return nil, fmt.Errorf("ASN %s 不正确", code)
+		}
+		codes = append(codes, code)
+	}
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("-as 参数不正确")
+	}
+	return codes, nil
+}
+
+// write 每行输出一个IP段，格式与测速的IP文件相同
+func (a *ASN) write(w io.Writer, prefixes []netip.Prefix) error {
+	writer := bufio.NewWriter(w)
+	for _, prefix := range prefixes {
+		if a.Port > 0 {
+			fmt.Fprintf(writer, "%s,%d\n", prefix, a.Port)
+		} else {
+			fmt.Fprintln(writer, prefix)
+		}
 	}
-	doc.Find("#table_prefixes4 tr a").Each(func(i int, s *goquery.Selection) {
-		content := s.Text()
-		fmt.Printf("%s\n", content)
-	})
+	return writer.Flush()
 }
@@ -0,0 +1,100 @@
+package asn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 模拟bgp.he.net的页面
+const page13335 = `<html><body>
+<table id="table_prefixes4"><tr><th>Prefix</th></tr>
+<tr><td><a href="/net/104.16.0.0/13">104.16.0.0/13</a></td></tr>
+<tr><td><a href="/net/172.64.0.0/13">172.64.0.0/13</a></td></tr>
+</table>
+<table id="table_prefixes6"><tr><th>Prefix</th></tr>
+<tr><td><a href="/net/2606:4700::/32">2606:4700::/32</a></td></tr>
+</table>
+</body></html>`
+
+const page209242 = `<html><body>
+<table id="table_prefixes4"><tr><th>Prefix</th></tr>
+<tr><td><a href="/net/104.16.0.0/13">104.16.0.0/13</a></td></tr>
+<tr><td><a href="/net/162.159.0.0/16">162.159.0.0/16</a></td></tr>
+</table>
+</body></html>`
+
+func newBGP(t *testing.T) {
+	pages := map[string]string{"/AS13335": page13335, "/AS209242": page209242}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(page))
+	}))
+	old := bgpURL
+	bgpURL = srv.URL + "/"
+	t.Cleanup(func() {
+		bgpURL = old
+		srv.Close()
+	})
+}
+
+func TestParseASNs(t *testing.T) {
+	codes, err := parseASNs(" 13335, AS209242,as1,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"13335", "209242", "1"}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("parseASNs() = %v, want %v", codes, want)
+	}
+	for _, value := range []string{"", ",", "AS", "13335x"} {
+		if _, err := parseASNs(value); err == nil {
+			t.Errorf("parseASNs(%q) should return an error", value)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	newBGP(t)
+	output := filepath.Join(t.TempDir(), "ip.txt")
+	a := &ASN{AsCode: "13335,209242", IPv6: true, Output: output, Port: 443, Source: "he"}
+	if err := a.Run(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "104.16.0.0/13,443\n162.159.0.0/16,443\n172.64.0.0/13,443\n2606:4700::/32,443\n"
+	if string(data) != want {
+		t.Errorf("output = %q, want %q", data, want)
+	}
+}
+
+func TestRunIPv4Only(t *testing.T) {
+	newBGP(t)
+	output := filepath.Join(t.TempDir(), "ip.txt")
+	a := &ASN{AsCode: "13335", Output: output, Source: "he"}
+	if err := a.Run(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(output)
+	if strings.Contains(string(data), ":") || strings.Count(string(data), "\n") != 2 {
+		t.Errorf("output = %q, want only the IPv4 prefixes", data)
+	}
+}
+
+func TestRunNotFound(t *testing.T) {
+	newBGP(t)
+	a := &ASN{AsCode: "1", Output: filepath.Join(t.TempDir(), "ip.txt"), Source: "he"}
+	if err := a.Run(); err == nil || !strings.Contains(err.Error(), "AS1") {
+		t.Errorf("Run() error = %v, want an error about AS1", err)
+	}
+}
@@ -0,0 +1,133 @@
+package asn
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// delegatedSource 从RIR的delegated-extended统计文件获取ASN持有者分配到的IP段。
+// 文件中的IP段是分配记录，不一定都在路由表中宣告，需要使用带opaque-id的extended格式
+type delegatedSource struct {
+	path   string
+	loaded bool
+	asns   []asnRecord
+	blocks map[string][]netip.Prefix // opaque-id到IP段
+}
+
+// asnRecord 一条ASN分配记录，从start开始的count个ASN属于同一个持有者
+type asnRecord struct {
+	start, count uint64
+	id           string
+}
+
+func (s *delegatedSource) Name() string {
+	return "delegated:" + s.path
+}
+
+func (s *delegatedSource) Prefixes(ctx context.Context, code string) ([]netip.Prefix, error) {
+	if !s.loaded {
+		body, err := open(ctx, s.path)
+		if err != nil {
+			return nil, err
+		}
+		err = s.load(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		s.loaded = true
+	}
+
+	asn, _ := strconv.ParseUint(code, 10, 32)
+	var prefixes []netip.Prefix
+	seen := make(map[string]bool)
+	for _, record := range s.asns {
+		if asn < record.start || asn >= record.start+record.count || seen[record.id] {
+			continue
+		}
+		seen[record.id] = true
+		prefixes = append(prefixes, s.blocks[record.id]...)
+	}
+	return prefixes, nil
+}
+
+// load 解析delegated文件，格式为registry|cc|type|start|value|date|status|opaque-id
+func (s *delegatedSource) load(reader io.Reader) error {
+	s.asns = nil
+	s.blocks = make(map[string][]netip.Prefix)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		// 跳过版本行和汇总行
+		if len(fields) < 7 || fields[1] == "*" {
+			continue
+		}
+		if status := fields[6]; status != "allocated" && status != "assigned" {
+			continue
+		}
+		if len(fields) < 8 || fields[7] == "" {
+			return fmt.Errorf("%s 不是extended格式，缺少opaque-id", line)
+		}
+		id := fields[7]
+		switch fields[2] {
+		case "asn":
+			start, err1 := strconv.ParseUint(fields[3], 10, 32)
+			count, err2 := strconv.ParseUint(fields[4], 10, 32)
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf("%s 格式不正确", line)
+			}
+			s.asns = append(s.asns, asnRecord{start: start, count: count, id: id})
+		case "ipv4":
+			start, err1 := netip.ParseAddr(fields[3])
+			count, err2 := strconv.ParseUint(fields[4], 10, 32)
+			if err1 != nil || err2 != nil || !start.Is4() {
+				return fmt.Errorf("%s 格式不正确", line)
+			}
+			s.blocks[id] = append(s.blocks[id], ipv4RangePrefixes(start, count)...)
+		case "ipv6":
+			start, err1 := netip.ParseAddr(fields[3])
+			length, err2 := strconv.Atoi(fields[4])
+			if err1 != nil || err2 != nil || !start.Is6() {
+				return fmt.Errorf("%s 格式不正确", line)
+			}
+			prefix, err := start.Prefix(length)
+			if err != nil {
+				return fmt.Errorf("%s 格式不正确", line)
+			}
+			s.blocks[id] = append(s.blocks[id], prefix)
+		}
+	}
+	return scanner.Err()
+}
+
+// ipv4RangePrefixes 把从start开始的count个IPv4地址拆分为CIDR，delegated文件中的个数不一定是2的幂
+func ipv4RangePrefixes(start netip.Addr, count uint64) []netip.Prefix {
+	var prefixes []netip.Prefix
+	b := start.As4()
+	cur := uint64(b[0])<<24 | uint64(b[1])<<16 | uint64(b[2])<<8 | uint64(b[3])
+	for count > 0 && cur < 1<<32 {
+		// 当前地址对齐的最大块，且不超过剩余个数
+		size := uint64(1) << 32
+		if cur != 0 {
+			size = cur & -cur
+		}
+		for size > count {
+			size >>= 1
+		}
+		addr := netip.AddrFrom4([4]byte{byte(cur >> 24), byte(cur >> 16), byte(cur >> 8), byte(cur)})
+		prefixes = append(prefixes, netip.PrefixFrom(addr, 32-bits.TrailingZeros64(size)))
+		cur += size
+		count -= size
+	}
+	return prefixes
+}
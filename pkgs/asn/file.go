@@ -0,0 +1,137 @@
+package asn
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// fileSource 从本地文件读取IP段，支持以下格式：
+//   - 每行一个IP段，不区分ASN
+//   - 每行IP段和起源ASN，用空白分隔，例如：1.1.1.0/24 13335
+//   - CAIDA的pfx2as格式，例如：1.1.1.0	24	13335
+//   - bgpdump -m输出的MRT路由表，起源ASN为AS_PATH的最后一个
+type fileSource struct {
+	path     string
+	loaded   bool
+	any      []netip.Prefix            // 没有ASN的IP段
+	prefixes map[string][]netip.Prefix // 起源ASN到IP段
+}
+
+func (s *fileSource) Name() string {
+	return "file:" + s.path
+}
+
+func (s *fileSource) Prefixes(ctx context.Context, code string) ([]netip.Prefix, error) {
+	if !s.loaded {
+		body, err := open(ctx, s.path)
+		if err != nil {
+			return nil, err
+		}
+		err = s.load(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		s.loaded = true
+	}
+	prefixes := append([]netip.Prefix{}, s.any...)
+	return append(prefixes, s.prefixes[code]...), nil
+}
+
+func (s *fileSource) load(reader io.Reader) error {
+	s.any = nil
+	s.prefixes = make(map[string][]netip.Prefix)
+	seen := make(map[string]map[netip.Prefix]bool)
+	add := func(prefix netip.Prefix, origins []string) {
+		prefix = prefix.Masked()
+		for _, origin := range origins {
+			if seen[origin] == nil {
+				seen[origin] = make(map[netip.Prefix]bool)
+			}
+			// 路由表中同一个IP段会出现在多个对等体的记录中
+			if seen[origin][prefix] {
+				continue
+			}
+			seen[origin][prefix] = true
+			if origin == "" {
+				s.any = append(s.any, prefix)
+			} else {
+				s.prefixes[origin] = append(s.prefixes[origin], prefix)
+			}
+		}
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		prefix, origins, err := parsePrefixLine(line)
+		if err != nil {
+			return err
+		}
+		if prefix.IsValid() {
+			add(prefix, origins)
+		}
+	}
+	return scanner.Err()
+}
+
+// parsePrefixLine 解析一行IP段，返回IP段和起源ASN，没有ASN时origins为[""]，
+// bgpdump中的撤销等记录返回无效的IP段
+func parsePrefixLine(line string) (netip.Prefix, []string, error) {
+	// bgpdump -m：TABLE_DUMP2|时间|B|对等体IP|对等体ASN|IP段|AS_PATH|...
+	if fields := strings.Split(line, "|"); len(fields) > 1 {
+		// 只处理路由表和宣告记录，跳过撤销和状态变化
+		if len(fields) > 2 && fields[2] != "B" && fields[2] != "A" {
+			return netip.Prefix{}, nil, nil
+		}
+		if len(fields) < 7 {
+			return netip.Prefix{}, nil, fmt.Errorf("%s 不是bgpdump -m的格式", line)
+		}
+		prefix, err := netip.ParsePrefix(fields[5])
+		if err != nil {
+			return netip.Prefix{}, nil, fmt.Errorf("%s IP段不正确", line)
+		}
+		path := strings.Fields(fields[6])
+		if len(path) == 0 {
+			return netip.Prefix{}, nil, nil
+		}
+		return prefix, splitASNs(path[len(path)-1]), nil
+	}
+
+	fields := strings.Fields(line)
+	var prefix netip.Prefix
+	var err error
+	switch {
+	case len(fields) == 3 && !strings.Contains(fields[0], "/"):
+		// pfx2as：IP 前缀长度 ASN
+		prefix, err = netip.ParsePrefix(fields[0] + "/" + fields[1])
+		fields = fields[1:]
+	case len(fields) <= 2:
+		prefix, err = netip.ParsePrefix(fields[0])
+	default:
+		err = fmt.Errorf("格式不正确")
+	}
+	if err != nil {
+		return netip.Prefix{}, nil, fmt.Errorf("%s 不是IP段", line)
+	}
+	if len(fields) == 1 {
+		return prefix, []string{""}, nil
+	}
+	return prefix, splitASNs(fields[1]), nil
+}
+
+// splitASNs 拆分AS_SET和多起源，例如{13335,209242}或13335_209242
+func splitASNs(value string) []string {
+	value = strings.Trim(value, "{}")
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '_'
+	})
+}
@@ -0,0 +1,44 @@
+package asn
+
+import (
+	"context"
+	"io"
+	"net/netip"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// 查询ASN的地址，测试时替换为本地地址
+var bgpURL = "https://bgp.he.net/"
+
+// heSource 从bgp.he.net的ASN页面解析IP段，页面改版或限流时会失败
+type heSource struct{}
+
+func (s *heSource) Name() string {
+	return "bgp.he.net"
+}
+
+func (s *heSource) Prefixes(ctx context.Context, code string) ([]netip.Prefix, error) {
+	body, err := open(ctx, bgpURL+"AS"+code)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return parseHEPage(body)
+}
+
+// parseHEPage 从bgp.he.net的页面中解析IPv4和IPv6的IP段
+func parseHEPage(reader io.Reader) ([]netip.Prefix, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []netip.Prefix
+	doc.Find("#table_prefixes4 tr a, #table_prefixes6 tr a").Each(func(i int, s *goquery.Selection) {
+		if prefix, err := netip.ParsePrefix(strings.TrimSpace(s.Text())); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		}
+	})
+	return prefixes, nil
+}
@@ -0,0 +1,52 @@
+package asn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+)
+
+// RIPEstat的announced-prefixes接口，测试时替换为本地地址
+var ripeStatURL = "https://stat.ripe.net/data/announced-prefixes/data.json"
+
+// ripeStatSource 从RIPEstat获取ASN在路由表中宣告的IP段
+type ripeStatSource struct{}
+
+type ripeStatResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		Prefixes []struct {
+			Prefix string `json:"prefix"`
+		} `json:"prefixes"`
+	} `json:"data"`
+}
+
+func (s *ripeStatSource) Name() string {
+	return "RIPEstat"
+}
+
+func (s *ripeStatSource) Prefixes(ctx context.Context, code string) ([]netip.Prefix, error) {
+	body, err := open(ctx, ripeStatURL+"?resource=AS"+code)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var resp ripeStatResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("返回的数据格式不正确: %w", err)
+	}
+	if resp.Status != "ok" {
+		return nil, fmt.Errorf("返回的状态为%s", resp.Status)
+	}
+	var prefixes []netip.Prefix
+	for _, item := range resp.Data.Prefixes {
+		prefix, err := netip.ParsePrefix(item.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("IP段 %s 不正确", item.Prefix)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,104 @@
+package asn
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultSource 默认数据源，与之前的版本相同只查询bgp.he.net
+const DefaultSource = "he"
+
+// httpClient 请求数据源使用的客户端，超时包含读取响应的时间，delegated文件较大，不能太短
+var httpClient = &http.Client{Timeout: 2 * time.Minute}
+
+// Source IP段数据源
+type Source interface {
+	// Name 数据源名称，用于输出提示
+	Name() string
+	// Prefixes 返回ASN宣告的IPv4和IPv6的IP段
+	Prefixes(ctx context.Context, code string) ([]netip.Prefix, error)
+}
+
+// ParseSources 解析英文逗号分隔的数据源，多个数据源依次尝试，前一个失败或没有结果时使用下一个。
+// 可选：he、ripestat、delegated:文件或地址、file:文件
+func ParseSources(value string) ([]Source, error) {
+	var sources []Source
+	for _, spec := range strings.Split(value, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(spec, ":")
+		switch strings.ToLower(name) {
+		case "he":
+			sources = append(sources, &heSource{})
+		case "ripestat":
+			sources = append(sources, &ripeStatSource{})
+		case "delegated":
+			if arg == "" {
+				return nil, fmt.Errorf("数据源 %s 需要指定文件或地址，例如delegated:delegated-ripencc-extended-latest", spec)
+			}
+			sources = append(sources, &delegatedSource{path: arg})
+		case "file":
+			if arg == "" {
+				return nil, fmt.Errorf("数据源 %s 需要指定文件，例如file:prefixes.txt", spec)
+			}
+			sources = append(sources, &fileSource{path: arg})
+		default:
+			return nil, fmt.Errorf("不支持数据源 %s，可选：he、ripestat、delegated:文件或地址、file:文件", spec)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("没有指定数据源")
+	}
+	return sources, nil
+}
+
+// fetchPrefixes 依次从数据源获取IP段，返回第一个有结果的数据源的IP段
+func fetchPrefixes(ctx context.Context, sources []Source, code string) ([]netip.Prefix, error) {
+	var errs []string
+	for i, source := range sources {
+		prefixes, err := source.Prefixes(ctx, code)
+		if err == nil && len(prefixes) > 0 {
+			return prefixes, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("没有找到IP段")
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", source.Name(), err))
+		if i < len(sources)-1 {
+			fmt.Fprintf(os.Stderr, "从%s获取AS%s的IP段失败，尝试%s: %v\n", source.Name(), code, sources[i+1].Name(), err)
+		}
+	}
+	return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+// open 打开本地文件或者http(s)地址
+func open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("请求 %s 失败，状态码 %d", path, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,192 @@
+package asn
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func prefixesString(prefixes []netip.Prefix) string {
+	var list []string
+	for _, prefix := range prefixes {
+		list = append(list, prefix.String())
+	}
+	return strings.Join(list, " ")
+}
+
+func TestParseSources(t *testing.T) {
+	sources, err := ParseSources("ripestat, he,file:a.txt,delegated:https://example.com/delegated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, source := range sources {
+		names = append(names, source.Name())
+	}
+	if want := "RIPEstat,bgp.he.net,file:a.txt,delegated:https://example.com/delegated"; strings.Join(names, ",") != want {
+		t.Errorf("ParseSources() = %v, want %s", names, want)
+	}
+	for _, value := range []string{"", "file", "delegated:", "bgpview"} {
+		if _, err := ParseSources(value); err == nil {
+			t.Errorf("ParseSources(%q) should return an error", value)
+		}
+	}
+}
+
+func TestRIPEStatSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("resource") != "AS13335" {
+			fmt.Fprint(w, `{"status":"ok","data":{"prefixes":[]}}`)
+			return
+		}
+		fmt.Fprint(w, `{"status":"ok","data":{"prefixes":[{"prefix":"1.1.1.0/24"},{"prefix":"2606:4700::/32"}]}}`)
+	}))
+	defer srv.Close()
+	old := ripeStatURL
+	ripeStatURL = srv.URL
+	defer func() { ripeStatURL = old }()
+
+	prefixes, err := (&ripeStatSource{}).Prefixes(context.Background(), "13335")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := prefixesString(prefixes); got != "1.1.1.0/24 2606:4700::/32" {
+		t.Errorf("Prefixes() = %s", got)
+	}
+}
+
+func TestDelegatedSource(t *testing.T) {
+	path := writeFile(t, "delegated", `2|ripencc|1700000000|4|19830705|20231114|+0100
+ripencc|*|ipv4|*|2|summary
+ripencc|NL|asn|64500|2|20100101|allocated|id-a
+ripencc|NL|ipv4|192.0.2.0|256|20100101|allocated|id-a
+ripencc|NL|ipv4|198.51.100.0|384|20100101|assigned|id-a
+ripencc|NL|ipv6|2001:db8::|32|20100101|allocated|id-a
+ripencc|DE|asn|64510|1|20100101|allocated|id-b
+ripencc|DE|ipv4|203.0.113.0|256|20100101|allocated|id-b
+ripencc||ipv4|10.0.0.0|256||available|
+`)
+	source := &delegatedSource{path: path}
+	prefixes, err := source.Prefixes(context.Background(), "64501")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := prefixesString(prefixes), "192.0.2.0/24 198.51.100.0/24 198.51.101.0/25 2001:db8::/32"; got != want {
+		t.Errorf("Prefixes(64501) = %s, want %s", got, want)
+	}
+	prefixes, _ = source.Prefixes(context.Background(), "64502")
+	if len(prefixes) != 0 {
+		t.Errorf("Prefixes(64502) = %v, want none", prefixes)
+	}
+
+	basic := writeFile(t, "delegated-basic", "ripencc|NL|asn|64500|1|20100101|allocated\n")
+	if _, err := (&delegatedSource{path: basic}).Prefixes(context.Background(), "64500"); err == nil {
+		t.Error("a delegated file without opaque-id should return an error")
+	}
+}
+
+func TestIPv4RangePrefixes(t *testing.T) {
+	tests := []struct {
+		start string
+		count uint64
+		want  string
+	}{
+		{"10.0.0.0", 256, "10.0.0.0/24"},
+		{"10.0.0.0", 768, "10.0.0.0/23 10.0.2.0/24"},
+		{"10.0.1.0", 768, "10.0.1.0/24 10.0.2.0/23"},
+		{"10.0.0.5", 3, "10.0.0.5/32 10.0.0.6/31"},
+		{"0.0.0.0", 1 << 32, "0.0.0.0/0"},
+	}
+	for _, tt := range tests {
+		got := prefixesString(ipv4RangePrefixes(netip.MustParseAddr(tt.start), tt.count))
+		if got != tt.want {
+			t.Errorf("ipv4RangePrefixes(%s, %d) = %s, want %s", tt.start, tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	path := writeFile(t, "rib.txt", `# 注释
+104.16.0.0/13
+1.1.1.0/24 13335
+1.0.0.0	24	13335_209242
+TABLE_DUMP2|1700000000|B|192.0.2.1|64500|162.159.0.0/16|64500 174 13335|IGP|192.0.2.1|0|0||NAG||
+TABLE_DUMP2|1700000000|B|192.0.2.2|64501|162.159.0.0/16|64501 13335|IGP|192.0.2.2|0|0||NAG||
+BGP4MP|1700000000|A|192.0.2.1|64500|2606:4700::/32|64500 {13335,209242}|IGP|192.0.2.1|0|0||NAG||
+BGP4MP|1700000000|W|192.0.2.1|64500|172.64.0.0/13
+TABLE_DUMP2|1700000000|B|192.0.2.1|64500|8.8.8.0/24|64500 15169|IGP|192.0.2.1|0|0||NAG||
+`)
+	source := &fileSource{path: path}
+	prefixes, err := source.Prefixes(context.Background(), "13335")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := prefixesString(prefixes), "104.16.0.0/13 1.1.1.0/24 1.0.0.0/24 162.159.0.0/16 2606:4700::/32"; got != want {
+		t.Errorf("Prefixes(13335) = %s, want %s", got, want)
+	}
+	prefixes, _ = source.Prefixes(context.Background(), "209242")
+	if got, want := prefixesString(prefixes), "104.16.0.0/13 1.0.0.0/24 2606:4700::/32"; got != want {
+		t.Errorf("Prefixes(209242) = %s, want %s", got, want)
+	}
+
+	bad := writeFile(t, "bad.txt", "not a prefix\n")
+	if _, err := (&fileSource{path: bad}).Prefixes(context.Background(), "13335"); err == nil {
+		t.Error("an invalid prefix file should return an error")
+	}
+}
+
+func TestFetchPrefixesFallback(t *testing.T) {
+	empty := writeFile(t, "empty.txt", "8.8.8.0/24 15169\n")
+	sources, err := ParseSources("file:" + filepath.Join(t.TempDir(), "missing.txt") + ",file:" + empty + ",file:" + writeFile(t, "ok.txt", "1.1.1.0/24 13335\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefixes, err := fetchPrefixes(context.Background(), sources, "13335")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := prefixesString(prefixes); got != "1.1.1.0/24" {
+		t.Errorf("fetchPrefixes() = %s, want 1.1.1.0/24", got)
+	}
+
+	if _, err := fetchPrefixes(context.Background(), sources[:2], "13335"); err == nil || !strings.Contains(err.Error(), "没有找到IP段") {
+		t.Errorf("fetchPrefixes() error = %v, want every source to fail", err)
+	}
+}
+
+func TestRunFileSource(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "ip.txt")
+	a := &ASN{AsCode: "13335", Output: output, Source: "file:" + writeFile(t, "prefixes.txt", "1.1.1.0/24 13335\n1.1.1.7/24 13335\n1.1.0.0/24 13335\n2606:4700::/32 13335\n")}
+	if err := a.Run(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(output)
+	if string(data) != "1.1.0.0/23\n" {
+		t.Errorf("output = %q, want 1.1.0.0/23", data)
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	a := &ASN{AsCode: "13335", IPv6: true, Source: "file:" + writeFile(t, "prefixes.txt", "1.1.1.0/24 13335\n1.1.0.0/24 13335\n2606:4700::/32 13335\n8.8.8.0/24 15169\n")}
+	prefixes, err := a.Prefixes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := prefixesString(prefixes); got != "1.1.0.0/23 2606:4700::/32" {
+		t.Errorf("Prefixes() = %s, want 1.1.0.0/23 2606:4700::/32", got)
+	}
+}
@@ -0,0 +1,126 @@
+// Package edgetest 提供一个模拟Cloudflare边缘节点的本地服务器，用于在不访问外网的情况下测试延迟、测速和输出流程，
+// 各接口由server包的测速源站实现
+package edgetest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/jackrun123/cfiptest/pkgs/server"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Config 模拟边缘节点的行为
+type Config struct {
+	Colo      string        // /cdn-cgi/trace和Cf-Meta-Colo响应头返回的数据中心，为空时使用HKG
+	Latency   time.Duration // 每个请求返回响应前等待的时间
+	Bandwidth int64         // 下载和上传每秒传输的字节数，0表示不限速
+	NoTLS     bool          // 使用HTTP而不是HTTPS
+	HTTP3     bool          // 同时在相同端口的UDP上提供HTTP/3，NoTLS为true时不生效
+	NoTrace   bool          // /cdn-cgi/trace返回404，模拟不是Cloudflare的IP
+	NoWS      bool          // /ws不支持websocket升级
+	Addr      string        // 监听地址，例如127.0.0.2:8443，为空时监听127.0.0.1的随机端口
+}
+
+// Server 模拟的边缘节点，默认监听在127.0.0.1的随机端口
+type Server struct {
+	IP   string // 监听的IP
+	Port int    // 监听的端口，HTTP/3使用相同的UDP端口
+
+	cfg    Config
+	origin *server.Server
+	srv    *httptest.Server
+	h3     *http3.Server
+	udp    net.PacketConn
+	mu     sync.Mutex
+	hits   map[string]int
+}
+
+// NewServer 启动一个模拟的边缘节点，测试结束时需要调用Close
+func NewServer(cfg Config) (*Server, error) {
+	if cfg.Colo == "" {
+		cfg.Colo = "HKG"
+	}
+	s := &Server{
+		cfg:    cfg,
+		origin: &server.Server{Colo: cfg.Colo, Bandwidth: cfg.Bandwidth},
+		hits:   make(map[string]int),
+	}
+	s.srv = httptest.NewUnstartedServer(s)
+	if cfg.Addr != "" {
+		ln, err := net.Listen("tcp", cfg.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("监听%s失败: %w", cfg.Addr, err)
+		}
+		s.srv.Listener.Close()
+		s.srv.Listener = ln
+	}
+	if cfg.NoTLS {
+		s.srv.Start()
+	} else {
+		s.srv.StartTLS()
+	}
+
+	addr := s.srv.Listener.Addr().(*net.TCPAddr)
+	s.IP = addr.IP.String()
+	s.Port = addr.Port
+
+	if cfg.HTTP3 && !cfg.NoTLS {
+		udp, err := net.ListenPacket("udp", s.srv.Listener.Addr().String())
+		if err != nil {
+			s.srv.Close()
+			return nil, fmt.Errorf("监听UDP端口失败: %w", err)
+		}
+		s.udp = udp
+		s.h3 = &http3.Server{
+			Handler:   s,
+			TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: s.srv.TLS.Certificates}),
+		}
+		go s.h3.Serve(udp)
+	}
+	return s, nil
+}
+
+// Close 关闭服务器
+func (s *Server) Close() {
+	if s.h3 != nil {
+		s.h3.Close()
+		s.udp.Close()
+	}
+	s.srv.Close()
+}
+
+// Hits 返回path被请求的次数
+func (s *Server) Hits(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[path]
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.hits[r.URL.Path]++
+	s.mu.Unlock()
+
+	if s.cfg.Latency > 0 {
+		select {
+		case <-time.After(s.cfg.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch {
+	case s.cfg.NoTrace && r.URL.Path == "/cdn-cgi/trace":
+		http.NotFound(w, r)
+	case s.cfg.NoWS && r.URL.Path == "/ws":
+		http.Error(w, "Expected Upgrade: websocket", http.StatusUpgradeRequired)
+	default:
+		s.origin.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,195 @@
+// Package prefixset 提供IP段集合，支持合并、去除和去重，IPv4和IPv6可以放在同一个集合中
+package prefixset
+
+import (
+	"net/netip"
+	"slices"
+	"sort"
+)
+
+// addrRange 连续的地址范围，包含from和to
+type addrRange struct {
+	from, to netip.Addr
+}
+
+// Set IP段集合，零值是空集合。内部保存排序后互不重叠也不相邻的地址范围
+type Set struct {
+	ranges []addrRange
+}
+
+// New 返回包含prefixes的集合
+func New(prefixes ...netip.Prefix) *Set {
+	s := &Set{}
+	for _, prefix := range prefixes {
+		s.Add(prefix)
+	}
+	return s
+}
+
+// Add 把IP段加入集合，与已有的IP段重叠或相邻时合并
+func (s *Set) Add(prefix netip.Prefix) {
+	if !prefix.IsValid() {
+		return
+	}
+	from, to := bounds(prefix)
+	// 与新范围重叠或相邻的范围为ranges[i:j]
+	lo := from.Prev()
+	if !lo.IsValid() || lo.BitLen() != from.BitLen() {
+		lo = from
+	}
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].to.Compare(lo) >= 0
+	})
+	hi := to.Next()
+	if !hi.IsValid() {
+		hi = to
+	}
+	j := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].from.Compare(hi) > 0
+	})
+	if i < j {
+		if s.ranges[i].from.Less(from) {
+			from = s.ranges[i].from
+		}
+		if to.Less(s.ranges[j-1].to) {
+			to = s.ranges[j-1].to
+		}
+	}
+	s.ranges = slices.Replace(s.ranges, i, j, addrRange{from, to})
+}
+
+// AddSet 把other中的全部IP段加入集合
+func (s *Set) AddSet(other *Set) {
+	for _, prefix := range other.Prefixes() {
+		s.Add(prefix)
+	}
+}
+
+// Remove 从集合中去除IP段
+func (s *Set) Remove(prefix netip.Prefix) {
+	if !prefix.IsValid() {
+		return
+	}
+	s.removeRange(bounds(prefix))
+}
+
+// RemoveSet 从集合中去除other中的全部IP段
+func (s *Set) RemoveSet(other *Set) {
+	for _, prefix := range other.Prefixes() {
+		s.Remove(prefix)
+	}
+}
+
+// Contains 判断地址是否在集合中
+func (s *Set) Contains(addr netip.Addr) bool {
+	return s.ContainsPrefix(netip.PrefixFrom(addr, addr.BitLen()))
+}
+
+// ContainsPrefix 判断IP段是否全部在集合中
+func (s *Set) ContainsPrefix(prefix netip.Prefix) bool {
+	if !prefix.IsValid() {
+		return false
+	}
+	from, to := bounds(prefix)
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].to.Compare(from) >= 0
+	})
+	return i < len(s.ranges) && s.ranges[i].from.Compare(from) <= 0 && s.ranges[i].to.Compare(to) >= 0
+}
+
+// Overlaps 判断IP段是否有部分地址在集合中
+func (s *Set) Overlaps(prefix netip.Prefix) bool {
+	if !prefix.IsValid() {
+		return false
+	}
+	from, to := bounds(prefix)
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].to.Compare(from) >= 0
+	})
+	return i < len(s.ranges) && s.ranges[i].from.Compare(to) <= 0
+}
+
+// Prefixes 返回覆盖集合的最少的IP段，按地址排序，IPv4在前
+func (s *Set) Prefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, r := range s.ranges {
+		prefixes = appendRange(prefixes, r.from, r.to)
+	}
+	return prefixes
+}
+
+// Subtract 返回prefix中不在集合里的部分
+func (s *Set) Subtract(prefix netip.Prefix) []netip.Prefix {
+	rest := New(prefix)
+	from, to := bounds(prefix)
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].to.Compare(from) >= 0
+	})
+	for ; i < len(s.ranges) && s.ranges[i].from.Compare(to) <= 0; i++ {
+		rest.removeRange(s.ranges[i].from, s.ranges[i].to)
+	}
+	return rest.Prefixes()
+}
+
+// removeRange 从集合中去除从from到to的地址范围
+func (s *Set) removeRange(from, to netip.Addr) {
+	// 与去除范围重叠的范围为ranges[i:j]
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].to.Compare(from) >= 0
+	})
+	j := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].from.Compare(to) > 0
+	})
+	if i >= j {
+		return
+	}
+	var rest []addrRange
+	if first := s.ranges[i]; first.from.Less(from) {
+		rest = append(rest, addrRange{first.from, from.Prev()})
+	}
+	if last := s.ranges[j-1]; to.Less(last.to) {
+		rest = append(rest, addrRange{to.Next(), last.to})
+	}
+	s.ranges = slices.Replace(s.ranges, i, j, rest...)
+}
+
+// bounds 返回IP段的第一个和最后一个地址
+func bounds(prefix netip.Prefix) (netip.Addr, netip.Addr) {
+	prefix = prefix.Masked()
+	return prefix.Addr(), lastAddr(prefix)
+}
+
+// lastAddr 返回IP段的最后一个地址
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	hostBits := len(b)*8 - prefix.Bits()
+	for i := len(b) - 1; i >= 0 && hostBits > 0; i-- {
+		n := min(hostBits, 8)
+		b[i] |= byte(1<<n - 1)
+		hostBits -= n
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// appendRange 把从from到to的地址范围拆分为最少的IP段
+func appendRange(prefixes []netip.Prefix, from, to netip.Addr) []netip.Prefix {
+	for from.IsValid() && from.Compare(to) <= 0 {
+		// 以from开头且不超过to的最大IP段
+		bits := from.BitLen()
+		for bits > 0 {
+			prefix := netip.PrefixFrom(from, bits-1)
+			if prefix.Masked().Addr() != from || to.Less(lastAddr(prefix)) {
+				break
+			}
+			bits--
+		}
+		prefix := netip.PrefixFrom(from, bits)
+		prefixes = append(prefixes, prefix)
+		from = lastAddr(prefix).Next()
+		if from.IsValid() && from.BitLen() != to.BitLen() {
+			break
+		}
+	}
+	return prefixes
+}
@@ -0,0 +1,149 @@
+package prefixset
+
+import (
+	"math/rand"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func parse(list string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Fields(list) {
+		prefixes = append(prefixes, netip.MustParsePrefix(s))
+	}
+	return prefixes
+}
+
+func format(prefixes []netip.Prefix) string {
+	var list []string
+	for _, prefix := range prefixes {
+		list = append(list, prefix.String())
+	}
+	return strings.Join(list, " ")
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		add  string
+		want string
+	}{
+		{"empty", "", ""},
+		{"duplicate", "1.1.1.0/24 1.1.1.0/24", "1.1.1.0/24"},
+		{"covered", "104.16.0.0/20 104.16.1.0/24 104.16.15.7/32", "104.16.0.0/20"},
+		{"covered first", "104.16.1.0/24 104.16.0.0/20", "104.16.0.0/20"},
+		{"adjacent", "10.0.0.0/24 10.0.1.0/24", "10.0.0.0/23"},
+		{"adjacent unaligned", "10.0.1.0/24 10.0.2.0/24", "10.0.1.0/24 10.0.2.0/24"},
+		{"bridge", "10.0.0.0/24 10.0.2.0/24 10.0.1.0/24 10.0.3.0/24", "10.0.0.0/22"},
+		{"host bits", "10.0.0.7/24", "10.0.0.0/24"},
+		{"edges", "0.0.0.0/1 128.0.0.0/1 ::/1 8000::/1", "0.0.0.0/0 ::/0"},
+		{"mixed", "2606:4700::/33 1.1.1.1/32 2606:4700:8000::/33 1.1.1.0/32", "1.1.1.0/31 2606:4700::/32"},
+	}
+	for _, tt := range tests {
+		if got := format(New(parse(tt.add)...).Prefixes()); got != tt.want {
+			t.Errorf("%s: Prefixes() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	s := New(parse("10.0.0.0/22 2001:db8::/32")...)
+	for _, prefix := range parse("10.0.1.0/24 10.0.3.128/25 192.0.2.0/24 2001:db8::/33") {
+		s.Remove(prefix)
+	}
+	if got, want := format(s.Prefixes()), "10.0.0.0/24 10.0.2.0/24 10.0.3.0/25 2001:db8:8000::/33"; got != want {
+		t.Errorf("Prefixes() = %s, want %s", got, want)
+	}
+	s.RemoveSet(New(parse("0.0.0.0/0 ::/0")...))
+	if got := s.Prefixes(); len(got) != 0 {
+		t.Errorf("Prefixes() = %v, want empty", got)
+	}
+}
+
+func TestContains(t *testing.T) {
+	s := New(parse("10.0.0.0/23 10.0.4.0/24")...)
+	for _, tt := range []struct {
+		prefix             string
+		contains, overlaps bool
+	}{
+		{"10.0.0.0/23", true, true},
+		{"10.0.1.255/32", true, true},
+		{"10.0.0.0/22", false, true},
+		{"10.0.2.0/23", false, false},
+		{"10.0.4.128/25", true, true},
+		{"9.0.0.0/8", false, false},
+		{"::/0", false, false},
+	} {
+		prefix := netip.MustParsePrefix(tt.prefix)
+		if got := s.ContainsPrefix(prefix); got != tt.contains {
+			t.Errorf("ContainsPrefix(%s) = %v, want %v", tt.prefix, got, tt.contains)
+		}
+		if got := s.Overlaps(prefix); got != tt.overlaps {
+			t.Errorf("Overlaps(%s) = %v, want %v", tt.prefix, got, tt.overlaps)
+		}
+	}
+	if !s.Contains(netip.MustParseAddr("10.0.4.1")) || s.Contains(netip.MustParseAddr("10.0.3.1")) {
+		t.Error("Contains() returned a wrong result")
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	s := New(parse("104.16.1.0/24 104.16.8.0/21")...)
+	if got, want := format(s.Subtract(netip.MustParsePrefix("104.16.0.0/20"))), "104.16.0.0/24 104.16.2.0/23 104.16.4.0/22"; got != want {
+		t.Errorf("Subtract() = %s, want %s", got, want)
+	}
+	if got := s.Subtract(netip.MustParsePrefix("104.16.9.0/24")); len(got) != 0 {
+		t.Errorf("Subtract() = %v, want empty", got)
+	}
+	if got := format(s.Subtract(netip.MustParsePrefix("1.1.1.0/24"))); got != "1.1.1.0/24" {
+		t.Errorf("Subtract() = %s, want 1.1.1.0/24", got)
+	}
+}
+
+// 随机加入和去除IP段，与逐个地址记录的结果对比
+func TestRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		s := &Set{}
+		var want [256]bool
+		for op := 0; op < 20; op++ {
+			bits := 24 + rnd.Intn(9)
+			prefix := netip.PrefixFrom(netip.AddrFrom4([4]byte{10, 0, 0, byte(rnd.Intn(256))}), bits).Masked()
+			from, to := bounds(prefix)
+			add := rnd.Intn(3) > 0
+			if add {
+				s.Add(prefix)
+			} else {
+				s.Remove(prefix)
+			}
+			for i := from.As4()[3]; ; i++ {
+				want[i] = add
+				if i == to.As4()[3] {
+					break
+				}
+			}
+		}
+
+		var got [256]bool
+		prefixes := s.Prefixes()
+		for i, prefix := range prefixes {
+			from, to := bounds(prefix)
+			for j := from.As4()[3]; ; j++ {
+				got[j] = true
+				if j == to.As4()[3] {
+					break
+				}
+			}
+			// 结果应该是最少的IP段，相邻且能合并的IP段不应该同时出现
+			if i > 0 && prefixes[i-1].Bits() == prefix.Bits() && lastAddr(prefixes[i-1]).Next() == from {
+				if merged := netip.PrefixFrom(prefixes[i-1].Addr(), prefix.Bits()-1); merged.Masked() == merged {
+					t.Fatalf("round %d: %v should be merged", round, prefixes)
+				}
+			}
+		}
+		if got != want {
+			t.Fatalf("round %d: Prefixes() = %v does not match", round, prefixes)
+		}
+	}
+}
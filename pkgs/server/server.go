@@ -0,0 +1,283 @@
+package server
+
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+const (
+	defaultDownloadBytes = 100000000   // 路径为空时的下载大小，与_worker.js相同
+	maxDownloadBytes     = 10000000000 // 单次下载的最大字节数，避免一个请求占用过长时间
+	defaultLocationsURL  = "https://speed.cloudflare.com/locations"
+	websocketGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	readHeaderTimeout    = 10 * time.Second // 读取请求头的超时时间，避免慢速客户端一直占用连接
+	idleTimeout          = 60 * time.Second // 空闲的keep-alive连接保留的时间
+	chunkSize            = 16 * 1024        // 下载时每次写入的数据大小
+)
+
+// 下载路径，例如/100m
+var sizePathRegexp = regexp.MustCompile(`^(\d+)([kmgKMG]?)$`)
+
+// Server 测速源站，实现与_worker.js相同的/ws、/locations、/<n>[kmg]、上传接口，以及/cdn-cgi/trace和/__down
+type Server struct {
+	Addr         string // 监听地址
+	CertFile     string // TLS证书，为空时使用HTTP
+	KeyFile      string // TLS私钥
+	HTTP3        bool   // 同时在相同端口的UDP上提供HTTP/3，需要TLS证书
+	Colo         string // /cdn-cgi/trace和Cf-Meta-Colo响应头返回的数据中心，为空时不返回Cf-Meta-Colo
+	Bandwidth    int64  // 下载和上传每秒传输的字节数，0表示不限速
+	LocationsURL string // /locations转发的地址，为空时使用speed.cloudflare.com
+}
+
+// Run 启动服务，出错时返回
+func (s *Server) Run() error {
+	if s.Addr == "" {
+		return fmt.Errorf("监听地址不能为空")
+	}
+	srv := s.httpServer()
+	if s.CertFile == "" {
+		fmt.Printf("测速服务监听 http://%s\n", s.Addr)
+		return srv.ListenAndServe()
+	}
+
+	errChan := make(chan error, 2)
+	if s.HTTP3 {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return err
+		}
+		h3 := &http3.Server{
+			Addr:      s.Addr,
+			Handler:   s,
+			TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		}
+		fmt.Printf("测速服务监听 https://%s (HTTP/3)\n", s.Addr)
+		go func() {
+			errChan <- h3.ListenAndServe()
+		}()
+	}
+	fmt.Printf("测速服务监听 https://%s\n", s.Addr)
+	go func() {
+		errChan <- srv.ListenAndServeTLS(s.CertFile, s.KeyFile)
+	}()
+	return <-errChan
+}
+
+// httpServer 返回设置了超时时间的HTTP服务。下载和上传的耗时与数据大小有关，不设置读写的总超时时间
+func (s *Server) httpServer() *http.Server {
+	return &http.Server{
+		Addr:              s.Addr,
+		Handler:           s,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Colo != "" {
+		w.Header().Set("Cf-Meta-Colo", s.Colo)
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	switch path {
+	case "ws":
+		s.serveWS(w, r)
+	case "up", "__up":
+		s.serveUp(w, r)
+	case "locations":
+		s.serveLocations(w, r)
+	case "cdn-cgi/trace":
+		s.serveTrace(w, r)
+	case "__down":
+		size, err := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64)
+		if err != nil || size < 0 || size > maxDownloadBytes {
+			http.Error(w, "bytes参数不正确", http.StatusBadRequest)
+			return
+		}
+		s.serveDown(w, r, size)
+	case "":
+		s.serveDown(w, r, defaultDownloadBytes)
+	default:
+		size, ok := parseSize(path)
+		if !ok {
+			http.Error(w, "路径格式不正确", http.StatusBadRequest)
+			return
+		}
+		s.serveDown(w, r, size)
+	}
+}
+
+// parseSize 解析下载路径，单位k、m、g分别表示1000、1000000、1000000000字节，超过maxDownloadBytes时返回false
+func parseSize(path string) (int64, bool) {
+	matches := sizePathRegexp.FindStringSubmatch(path)
+	if matches == nil {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	unit := int64(1)
+	switch strings.ToLower(matches[2]) {
+	case "k":
+		unit = 1000
+	case "m":
+		unit = 1000000
+	case "g":
+		unit = 1000000000
+	}
+	// 先比较再相乘，避免溢出
+	if size > maxDownloadBytes/unit {
+		return 0, false
+	}
+	return size * unit, true
+}
+
+// serveTrace 返回与Cloudflare格式相同的trace信息，通过Cloudflare访问时由Cloudflare直接返回
+func (s *Server) serveTrace(w http.ResponseWriter, r *http.Request) {
+	colo := s.Colo
+	if colo == "" {
+		colo = "LOCAL"
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "fl=0\nh=%s\nip=%s\nts=%.3f\nvisit_scheme=%s\nuag=%s\ncolo=%s\nsliver=none\nhttp=%s\nloc=XX\ntls=TLSv1.3\nsni=plaintext\nwarp=off\ngateway=off\nrbi=off\nkex=X25519\n",
+		r.Host, ip, float64(time.Now().UnixMilli())/1000, scheme, r.UserAgent(), colo, strings.ToLower(r.Proto))
+}
+
+// serveDown 返回size字节的数据，按Bandwidth限速
+func (s *Server) serveDown(w http.ResponseWriter, r *http.Request, size int64) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+
+	buf := make([]byte, chunkSize)
+	start := time.Now()
+	var written int64
+	for written < size {
+		n := min(int64(len(buf)), size-written)
+		if _, err := w.Write(buf[:n]); err != nil {
+			return
+		}
+		written += n
+		if s.Bandwidth > 0 {
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			if !s.throttle(r, start, written) {
+				return
+			}
+		}
+	}
+}
+
+// serveUp 读取并丢弃上传的数据，返回接收到的字节数
+func (s *Server) serveUp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Expected POST", http.StatusMethodNotAllowed)
+		return
+	}
+	buf := make([]byte, chunkSize)
+	start := time.Now()
+	var received int64
+	for {
+		n, err := r.Body.Read(buf)
+		received += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil || !s.throttle(r, start, received) {
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"bytes": received})
+}
+
+// throttle 传输速度超过Bandwidth时等待，请求被取消时返回false
+func (s *Server) throttle(r *http.Request, start time.Time, transferred int64) bool {
+	if s.Bandwidth <= 0 {
+		return true
+	}
+	expected := time.Duration(float64(transferred) / float64(s.Bandwidth) * float64(time.Second))
+	wait := expected - time.Since(start)
+	if wait <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(wait):
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// serveLocations 转发Cloudflare的数据中心列表
+func (s *Server) serveLocations(w http.ResponseWriter, r *http.Request) {
+	target := s.LocationsURL
+	if target == "" {
+		target = defaultLocationsURL
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, target, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("User-Agent", r.UserAgent())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// serveWS 完成websocket握手，之后读取并丢弃客户端发送的数据，直到连接关闭
+func (s *Server) serveWS(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		http.Error(w, "Expected Upgrade: websocket", http.StatusUpgradeRequired)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "不支持websocket", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	h := sha1.New()
+	h.Write([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n", accept)
+	if s.Colo != "" {
+		fmt.Fprintf(rw, "Cf-Meta-Colo: %s\r\n", s.Colo)
+	}
+	rw.WriteString("\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+	io.Copy(io.Discard, rw)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		path string
+		size int64
+		ok   bool
+	}{
+		{"100", 100, true},
+		{"10k", 10000, true},
+		{"25M", 25000000, true},
+		{"1g", 1000000000, true},
+		{"10g", 10000000000, true},
+		{"11g", 0, false},
+		{"10000000001", 0, false},
+		{"10000000000g", 0, false},
+		{"99999999999999999999", 0, false},
+		{"10x", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		size, ok := parseSize(tt.path)
+		if size != tt.size || ok != tt.ok {
+			t.Errorf("parseSize(%s) = %d, %t, want %d, %t", tt.path, size, ok, tt.size, tt.ok)
+		}
+	}
+}
+
+func TestHTTPServerTimeouts(t *testing.T) {
+	srv := (&Server{Addr: "127.0.0.1:0"}).httpServer()
+	if srv.ReadHeaderTimeout <= 0 || srv.IdleTimeout <= 0 {
+		t.Errorf("ReadHeaderTimeout = %s, IdleTimeout = %s", srv.ReadHeaderTimeout, srv.IdleTimeout)
+	}
+	// 大文件下载和上传不能被总超时时间中断
+	if srv.ReadTimeout != 0 || srv.WriteTimeout != 0 {
+		t.Errorf("ReadTimeout = %s, WriteTimeout = %s", srv.ReadTimeout, srv.WriteTimeout)
+	}
+}
+
+func TestServer(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `[{"iata":"HKG"}]`)
+	}))
+	defer upstream.Close()
+	srv := httptest.NewServer(&Server{Colo: "HKG", LocationsURL: upstream.URL})
+	defer srv.Close()
+
+	get := func(method, path string, body io.Reader) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, body)
+		req.Header.Set("User-Agent", "Mozilla/5.0 test")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+
+	if resp, body := get("GET", "/2k", nil); resp.StatusCode != 200 || len(body) != 2000 || resp.Header.Get("Cf-Meta-Colo") != "HKG" {
+		t.Errorf("/2k = %d, %d bytes, colo %s", resp.StatusCode, len(body), resp.Header.Get("Cf-Meta-Colo"))
+	}
+	if _, body := get("GET", "/__down?bytes=123", nil); len(body) != 123 {
+		t.Errorf("/__down got %d bytes, want 123", len(body))
+	}
+	if resp, _ := get("GET", "/12x", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("/12x status = %d", resp.StatusCode)
+	}
+	if resp, _ := get("GET", "/__down?bytes=10000000001", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("/__down over the limit status = %d", resp.StatusCode)
+	}
+	if resp, _ := get("GET", "/10000000000g", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("/10000000000g status = %d", resp.StatusCode)
+	}
+	if _, body := get("POST", "/up", strings.NewReader(strings.Repeat("a", 4096))); strings.TrimSpace(body) != `{"bytes":4096}` {
+		t.Errorf("/up = %s", body)
+	}
+	if resp, _ := get("GET", "/up", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /up status = %d", resp.StatusCode)
+	}
+	if _, body := get("GET", "/cdn-cgi/trace", nil); !strings.Contains(body, "uag=Mozilla/5.0 test\n") || !strings.Contains(body, "colo=HKG\n") {
+		t.Errorf("/cdn-cgi/trace = %s", body)
+	}
+	if _, body := get("GET", "/locations", nil); body != `[{"iata":"HKG"}]` {
+		t.Errorf("/locations = %s", body)
+	}
+	if resp, _ := get("GET", "/ws", nil); resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("/ws without upgrade status = %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/ws", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("/ws = %d, accept %s", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+}
@@ -0,0 +1,289 @@
+package speed
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 进度文件的保存间隔
+const checkpointInterval = 10 * time.Second
+
+// checkpointState 进度文件的内容
+type checkpointState struct {
+	Fingerprint string             `json:"fingerprint"`  // IP文件内容和生成IP相关参数的摘要，不一致时不能继续
+	Seed        int64              `json:"seed"`         // 抽样和打乱顺序使用的随机种子
+	Next        int64              `json:"next"`         // 序号小于Next的IP都已完成延迟测试
+	Done        []int64            `json:"done"`         // 序号不小于Next但已完成延迟测试的IP
+	DelayDone   bool               `json:"delay_done"`   // 延迟测试是否已全部完成
+	Delay       []Result           `json:"delay"`        // 有效的延迟测试结果
+	SpeedTested []string           `json:"speed_tested"` // 已完成测速的IP，包括不符合条件的
+	Speed       []*SpeedTestResult `json:"speed"`        // 符合条件的测速结果
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+// checkpoint 记录测试进度，定期保存到文件，中断后可以从文件继续测试
+type checkpoint struct {
+	mu          sync.Mutex
+	path        string
+	state       checkpointState
+	done        map[int64]struct{}
+	speedTested map[string]struct{}
+	dirty       bool
+	stop        chan struct{}
+	wg          sync.WaitGroup
+}
+
+// fingerprint 计算IP列表、排除的IP段文件和影响IP生成顺序的参数的摘要
+func (st *CFSpeedTest) fingerprint() (string, error) {
+	content, err := st.ipContent()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(content)
+	fmt.Fprintf(h, "\n%d,%t,%d,%d,%d", st.DefaultPort, st.Shuffle, st.SampleCount, st.SamplePrefix, st.SamplePrefix6)
+	// 排除的IP段也会影响生成的IP
+	if st.ExcludeFile != "" {
+		exclude, err := os.ReadFile(st.ExcludeFile)
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte("\n"))
+		h.Write(exclude)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// openCheckpoint 创建进度文件，Resume为true时从进度文件恢复随机种子和已完成的结果
+func (st *CFSpeedTest) openCheckpoint() error {
+	if st.StateFile == "" {
+		if st.Resume {
+			return errors.New("继续测试需要指定进度文件")
+		}
+		return nil
+	}
+	fingerprint, err := st.fingerprint()
+	if err != nil {
+		return err
+	}
+
+	cp := &checkpoint{
+		path:        st.StateFile,
+		done:        make(map[int64]struct{}),
+		speedTested: make(map[string]struct{}),
+		stop:        make(chan struct{}),
+	}
+	if st.Resume {
+		data, err := os.ReadFile(st.StateFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &cp.state); err != nil {
+			return fmt.Errorf("进度文件 %s 格式不正确: %w", st.StateFile, err)
+		}
+		if cp.state.Fingerprint != fingerprint {
+			return fmt.Errorf("IP文件或抽样、打乱顺序参数与进度文件 %s 不一致，无法继续测试", st.StateFile)
+		}
+		for _, seq := range cp.state.Done {
+			cp.done[seq] = struct{}{}
+		}
+		for _, ip := range cp.state.SpeedTested {
+			cp.speedTested[ip] = struct{}{}
+		}
+		st.Seed = cp.state.Seed
+		st.printf("从进度文件继续测试，已完成延迟测试：%d，有效IP：%d，已完成测速：%d\n",
+			cp.state.Next+int64(len(cp.done)), len(cp.state.Delay), len(cp.state.SpeedTested))
+	} else {
+		cp.state = checkpointState{Fingerprint: fingerprint, Seed: st.Seed}
+		cp.dirty = true
+	}
+
+	st.checkpoint = cp
+	cp.wg.Add(1)
+	go cp.autoSave(st)
+	return nil
+}
+
+// autoSave 定期保存进度
+func (cp *checkpoint) autoSave(st *CFSpeedTest) {
+	defer cp.wg.Done()
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cp.stop:
+			return
+		case <-ticker.C:
+			if err := cp.save(); err != nil && st.VerboseMode {
+				st.printf("保存进度失败: %v\n", err)
+			}
+		}
+	}
+}
+
+// save 有变化时写入进度文件，先写临时文件再重命名，避免中断时文件损坏
+func (cp *checkpoint) save() error {
+	cp.mu.Lock()
+	if !cp.dirty {
+		cp.mu.Unlock()
+		return nil
+	}
+	cp.state.Done = cp.state.Done[:0]
+	for seq := range cp.done {
+		cp.state.Done = append(cp.state.Done, seq)
+	}
+	cp.state.UpdatedAt = time.Now()
+	data, err := json.Marshal(&cp.state)
+	cp.dirty = false
+	cp.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(cp.path, data, 0600)
+}
+
+// writeFileAtomic 先写入同一目录下的临时文件再重命名，写入中断时不会留下不完整的文件
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// close 停止定期保存，测试完成时删除进度文件，否则保存最终进度
+func (cp *checkpoint) close(completed bool) error {
+	close(cp.stop)
+	cp.wg.Wait()
+	if completed {
+		err := os.Remove(cp.path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return cp.save()
+}
+
+// skipDone 跳过序号小于Next的IP，返回跳过的个数
+func (cp *checkpoint) skipDone(ips IpIterator) int64 {
+	var skipped int64
+	for skipped < cp.state.Next {
+		if _, ok := ips.Next(); !ok {
+			break
+		}
+		skipped++
+	}
+	return skipped
+}
+
+// isDone 返回序号为seq的IP是否已完成延迟测试
+func (cp *checkpoint) isDone(seq int64) bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	_, ok := cp.done[seq]
+	return ok || seq < cp.state.Next
+}
+
+// delayDone 记录序号为seq的IP已完成延迟测试，result为nil表示无效或被过滤
+func (cp *checkpoint) delayDone(seq int64, result *Result) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if result != nil {
+		cp.state.Delay = append(cp.state.Delay, *result)
+	}
+	cp.done[seq] = struct{}{}
+	for {
+		if _, ok := cp.done[cp.state.Next]; !ok {
+			break
+		}
+		delete(cp.done, cp.state.Next)
+		cp.state.Next++
+	}
+	cp.dirty = true
+}
+
+// finishDelay 标记延迟测试已全部完成
+func (cp *checkpoint) finishDelay() {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.state.DelayDone = true
+	cp.dirty = true
+}
+
+// speedDone 记录ip已完成测速，result为nil表示不符合条件
+func (cp *checkpoint) speedDone(ip string, result *SpeedTestResult) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if result != nil {
+		cp.state.Speed = append(cp.state.Speed, result)
+	}
+	cp.state.SpeedTested = append(cp.state.SpeedTested, ip)
+	cp.speedTested[ip] = struct{}{}
+	cp.dirty = true
+}
+
+// restoreDownload 返回已保存的测速结果，并从resultChan中去掉已测速的IP
+func (cp *checkpoint) restoreDownload(resultChan chan Result) ([]*SpeedTestResult, chan Result) {
+	var pending []Result
+	for res := range resultChan {
+		ipPair := IpPair{IP: res.IP, Port: res.Port}
+		if _, ok := cp.speedTested[ipPair.String()]; !ok {
+			pending = append(pending, res)
+		}
+	}
+	remain := make(chan Result, len(pending))
+	for _, res := range pending {
+		remain <- res
+	}
+	close(remain)
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return append([]*SpeedTestResult(nil), cp.state.Speed...), remain
+}
+
+// abortCheckpoint 打开进度文件后出错时停止定期保存，不删除也不更新进度文件
+func (st *CFSpeedTest) abortCheckpoint() {
+	if st.checkpoint == nil {
+		return
+	}
+	close(st.checkpoint.stop)
+	st.checkpoint.wg.Wait()
+	st.checkpoint = nil
+}
+
+// closeCheckpoint 测试完成时删除进度文件，被取消时保存进度
+func (st *CFSpeedTest) closeCheckpoint(ctx context.Context) {
+	if st.checkpoint == nil {
+		return
+	}
+	completed := ctx.Err() == nil
+	if err := st.checkpoint.close(completed); err != nil {
+		st.printf("保存进度失败: %v\n", err)
+	} else if !completed {
+		st.printf("进度已保存到 %s，使用 -resume 继续测试\n", st.StateFile)
+	}
+	st.checkpoint = nil
+}
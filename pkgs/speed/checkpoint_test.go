@@ -0,0 +1,102 @@
+package speed
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	ipFile := filepath.Join(dir, "ip.txt")
+	if err := os.WriteFile(ipFile, []byte("10.0.0.0/24,443,sample=4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stateFile := filepath.Join(dir, "state.json")
+
+	st := &CFSpeedTest{IpFile: ipFile, StateFile: stateFile, DefaultPort: 443, SamplePrefix: 24, Seed: 42}
+	if err := st.openCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	ips, err := st.readIPs(ipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all []IpPair
+	for {
+		ip, ok := ips.Next()
+		if !ok {
+			break
+		}
+		all = append(all, ip)
+	}
+
+	// 第0、1、3个IP完成，第2个IP未完成
+	cp := st.checkpoint
+	cp.delayDone(0, &Result{IP: all[0].IP, Port: 443})
+	cp.delayDone(1, nil)
+	cp.delayDone(3, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	st.closeCheckpoint(ctx)
+
+	resumed := &CFSpeedTest{IpFile: ipFile, StateFile: stateFile, DefaultPort: 443, SamplePrefix: 24, Resume: true}
+	if err := resumed.openCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.closeCheckpoint(context.Background())
+	if resumed.Seed != 42 {
+		t.Errorf("Seed = %d, want 42", resumed.Seed)
+	}
+	ips, err = resumed.readIPs(ipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp = resumed.checkpoint
+	if skipped := cp.skipDone(ips); skipped != 2 {
+		t.Fatalf("skipDone() = %d, want 2", skipped)
+	}
+	if ip, _ := ips.Next(); ip != all[2] {
+		t.Errorf("Next() = %s, want %s", ip.String(), all[2].String())
+	}
+	if cp.isDone(2) || !cp.isDone(3) {
+		t.Errorf("isDone(2) = %t, isDone(3) = %t", cp.isDone(2), cp.isDone(3))
+	}
+	if len(cp.state.Delay) != 1 || cp.state.Delay[0].IP != all[0].IP {
+		t.Errorf("Delay = %v", cp.state.Delay)
+	}
+
+	// IP文件变化后不能继续
+	if err := os.WriteFile(ipFile, []byte("10.0.1.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed := &CFSpeedTest{IpFile: ipFile, StateFile: stateFile, DefaultPort: 443, SamplePrefix: 24, Resume: true}
+	if err := changed.openCheckpoint(); err == nil {
+		t.Error("openCheckpoint() with changed ip file should fail")
+	}
+}
+
+func TestRunAbortCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	ipFile := filepath.Join(dir, "ip.txt")
+	excludeFile := filepath.Join(dir, "exclude.txt")
+	if err := os.WriteFile(ipFile, []byte("10.0.0.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 排除文件格式不正确，打开进度文件后读取IP失败
+	if err := os.WriteFile(excludeFile, []byte("not an ip\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stateFile := filepath.Join(dir, "state.json")
+
+	st := &CFSpeedTest{IpFile: ipFile, ExcludeFile: excludeFile, StateFile: stateFile, OutFile: filepath.Join(dir, "out.csv"), Stdout: io.Discard}
+	st.Run(context.Background())
+	if st.checkpoint != nil {
+		t.Error("checkpoint still open after readIPs failed")
+	}
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Errorf("state file should not be written, stat err = %v", err)
+	}
+}
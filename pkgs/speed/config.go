@@ -0,0 +1,96 @@
+package speed
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// 配置文件中保存命名配置的字段
+const profilesKey = "profiles"
+
+// LoadConfig 从YAML或TOML配置文件读取参数，文件扩展名为.toml时按TOML解析，否则按YAML解析。
+// 文件顶层的参数先生效，profile不为空时再用profiles中同名配置覆盖，文件中没有出现的参数保持不变
+func (st *CFSpeedTest) LoadConfig(file, profile string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]any)
+	if strings.EqualFold(filepath.Ext(file), ".toml") {
+		err = toml.Unmarshal(data, &values)
+	} else {
+		err = yaml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return fmt.Errorf("配置文件 %s 格式不正确: %w", file, err)
+	}
+
+	profiles, err := parseProfiles(values[profilesKey])
+	if err != nil {
+		return fmt.Errorf("配置文件 %s 的%s不正确: %w", file, profilesKey, err)
+	}
+	delete(values, profilesKey)
+	if err := st.applyConfig(values); err != nil {
+		return fmt.Errorf("配置文件 %s 不正确: %w", file, err)
+	}
+
+	if profile == "" {
+		return nil
+	}
+	values, ok := profiles[profile]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("配置文件 %s 中没有配置 %s，可选：%s", file, profile, strings.Join(names, ", "))
+	}
+	if err := st.applyConfig(values); err != nil {
+		return fmt.Errorf("配置 %s 不正确: %w", profile, err)
+	}
+	return nil
+}
+
+// parseProfiles 解析命名配置，每个配置都是参数名到值的映射
+func parseProfiles(value any) (map[string]map[string]any, error) {
+	profiles := make(map[string]map[string]any)
+	if value == nil {
+		return profiles, nil
+	}
+	m, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("应为配置名到参数的映射")
+	}
+	for name, v := range m {
+		values, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s 应为参数名到值的映射", name)
+		}
+		profiles[name] = values
+	}
+	return profiles, nil
+}
+
+// applyConfig 把参数写入对应的字段，不认识的参数名返回错误
+func (st *CFSpeedTest) applyConfig(values map[string]any) error {
+	if len(values) == 0 {
+		return nil
+	}
+	// TOML和YAML解析出的值统一转换为YAML后按字段的yaml标签赋值
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	return decoder.Decode(st)
+}
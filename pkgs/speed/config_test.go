@@ -0,0 +1,78 @@
+package speed
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "cfiptest.yaml")
+	yamlContent := `
+max_thread: 200
+speed_test_url: example.com/100m
+filter_iata: HKG,NRT
+profiles:
+  mobile:
+    download_protocol: h3
+    max_thread: 50
+  home-v6:
+    ip_file: ipv6.txt
+    sample_count: 2
+`
+	tomlFile := filepath.Join(dir, "cfiptest.toml")
+	tomlContent := `
+max_thread = 200
+speed_test_url = "example.com/100m"
+filter_iata = "HKG,NRT"
+
+[profiles.mobile]
+download_protocol = "h3"
+max_thread = 50
+
+[profiles.home-v6]
+ip_file = "ipv6.txt"
+sample_count = 2
+`
+	if err := os.WriteFile(yamlFile, []byte(yamlContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tomlFile, []byte(tomlContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{yamlFile, tomlFile} {
+		st := &CFSpeedTest{IpFile: "ip.txt", MaxThread: 100, MinSpeed: 1}
+		if err := st.LoadConfig(file, "mobile"); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if st.MaxThread != 50 || st.DownloadProtocol != "h3" || st.SpeedTestURL != "example.com/100m" || st.FilterIATA != "HKG,NRT" {
+			t.Errorf("%s mobile: %+v", file, st)
+		}
+		// 配置文件中没有的参数保持不变
+		if st.IpFile != "ip.txt" || st.MinSpeed != 1 {
+			t.Errorf("%s: ip_file = %s, min_speed = %.1f", file, st.IpFile, st.MinSpeed)
+		}
+
+		st = &CFSpeedTest{}
+		if err := st.LoadConfig(file, "home-v6"); err != nil {
+			t.Fatal(err)
+		}
+		if st.MaxThread != 200 || st.IpFile != "ipv6.txt" || st.SampleCount != 2 {
+			t.Errorf("%s home-v6: %+v", file, st)
+		}
+
+		if err := (&CFSpeedTest{}).LoadConfig(file, "office"); err == nil {
+			t.Errorf("%s: unknown profile should fail", file)
+		}
+	}
+
+	badFile := filepath.Join(dir, "bad.yaml")
+	if err := os.WriteFile(badFile, []byte("max_threads: 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&CFSpeedTest{}).LoadConfig(badFile, ""); err == nil {
+		t.Error("unknown field should fail")
+	}
+}
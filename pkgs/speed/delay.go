@@ -30,17 +30,21 @@ func (st *CFSpeedTest) GetDelayTestURL() string {
 	return requestURL
 }
 
-func (st *CFSpeedTest) showPercentText(count *atomic.Int64, okCount *atomic.Int64, total *int) {
-	percentage := float64(count.Load()) / float64(*total) * 100
-	fmt.Printf("已完成: %d/%d(%.2f%%)，有效个数：%d", count.Load(), *total, percentage, okCount.Load())
-	if count.Load() == int64(*total) {
-		fmt.Printf("\n")
+func (st *CFSpeedTest) showPercentText(count *atomic.Int64, okCount *atomic.Int64, total int64) {
+	if total > 0 {
+		percentage := float64(count.Load()) / float64(total) * 100
+		st.printf("已完成: %d/%d(%.2f%%)，有效个数：%d", count.Load(), total, percentage, okCount.Load())
 	} else {
-		fmt.Printf("\r")
+		st.printf("已完成: %d，有效个数：%d", count.Load(), okCount.Load())
+	}
+	if count.Load() == total {
+		st.printf("\n")
+	} else {
+		st.printf("\r")
 	}
 }
 
-func (st *CFSpeedTest) showPercent(stop <-chan struct{}, count *atomic.Int64, okCount *atomic.Int64, total *int) {
+func (st *CFSpeedTest) showPercent(stop <-chan struct{}, count *atomic.Int64, okCount *atomic.Int64, total int64) {
 	go func() {
 		ticker := time.NewTicker(1 * time.Second)
 		defer ticker.Stop()
@@ -55,124 +59,222 @@ func (st *CFSpeedTest) showPercent(stop <-chan struct{}, count *atomic.Int64, ok
 	}()
 }
 
-func (st *CFSpeedTest) TestDelay(ips []IpPair) chan Result {
+func (st *CFSpeedTest) TestDelay(ctx context.Context, ips IpIterator) chan Result {
 	var wg sync.WaitGroup
 
-	resultChan := make(chan Result, len(ips))
+	// IP总数可能非常大，只保存有效结果
+	var results []Result
+	mu := sync.Mutex{}
 
 	thread := make(chan struct{}, st.MaxThread)
 
 	count := atomic.Int64{}
 	okCount := atomic.Int64{}
-	total := len(ips)
+	total := ips.Total()
+
+	// 从进度文件继续测试时，恢复已完成的结果并跳过已测试的IP
+	cp := st.checkpoint
+	var seq int64
+	if cp != nil {
+		results = append(results, cp.state.Delay...)
+		okCount.Store(int64(len(results)))
+		if st.SpeedTestThread <= 0 {
+			for _, result := range results {
+				st.emitResult(&SpeedTestResult{Result: result}, "delay")
+			}
+		}
+		if cp.state.DelayDone {
+			ips = &sliceIterator{}
+		} else {
+			seq = cp.skipDone(ips)
+			count.Store(seq)
+		}
+	}
+
 	stopShowPercent := make(chan struct{})
-	st.showPercent(stopShowPercent, &count, &okCount, &total)
-	for _, ip := range ips {
+	st.showPercent(stopShowPercent, &count, &okCount, total)
+	for ctx.Err() == nil {
 		// 如果满足延迟测试条数，则跳过
 		if st.MaxDelayCount > 0 && okCount.Load() >= int64(st.MaxDelayCount) {
 			break
 		}
+		ip, ok := ips.Next()
+		if !ok {
+			break
+		}
+		id := seq
+		seq++
+		if cp != nil && cp.isDone(id) {
+			count.Add(1)
+			continue
+		}
 
 		wg.Add(1)
 		thread <- struct{}{}
-		go func(ipPair IpPair) {
+		go func(ipPair IpPair, id int64) {
 			defer func() {
 				wg.Done()
 				count.Add(1)
 				<-thread
 			}()
 
-			var result *Result
-			var err error
-			if st.DelayTestType == 1 {
-				result, err = st.TestTCP(ipPair)
-			} else if st.DelayTestType == 0 {
-				result, err = st.TestDelayOnce(ipPair)
-			} else {
-				return
-			}
+			result, err := st.TestDelayMulti(ctx, ipPair)
 
+			var valid *Result
 			if result != nil {
 				filterStr := ""
-				if st.FilterIATASet != nil && st.FilterIATASet[result.dataCenter] == nil {
+				if st.isFiltered(result) {
 					filterStr = "，但被过滤"
 				} else {
-					resultChan <- *result
+					valid = result
+					mu.Lock()
+					results = append(results, *result)
+					mu.Unlock()
 					okCount.Add(1)
+					if st.SpeedTestThread <= 0 {
+						st.emitResult(&SpeedTestResult{Result: *result}, "delay")
+					}
 				}
-				fmt.Printf("发现有效IP %s 位置信息 %s 延迟 %d 毫秒%s\n", ipPair.String(), result.city, result.tcpDuration.Milliseconds(), filterStr)
+				st.printf("发现有效IP %s 位置信息 %s 延迟 %d 毫秒%s\n", ipPair.String(), result.City, result.TCPDuration.Milliseconds(), filterStr)
 			}
 			if err != nil && st.VerboseMode {
-				fmt.Printf("IP %s 错误, err: %s \n", ipPair.String(), err)
+				st.printf("IP %s 错误, err: %s \n", ipPair.String(), err)
+			}
+			// 被取消的测试结果不完整，继续测试时需要重新测试
+			if cp != nil && ctx.Err() == nil {
+				cp.delayDone(id, valid)
 			}
 
-		}(ip)
+		}(ip, id)
 	}
 
 	wg.Wait()
+	if cp != nil && ctx.Err() == nil {
+		cp.finishDelay()
+	}
 	stopShowPercent <- struct{}{}
 	close(stopShowPercent)
-	close(resultChan)
-	st.showPercentText(&count, &okCount, &total)
+	st.showPercentText(&count, &okCount, total)
+	if count.Load() != total {
+		st.printf("\n")
+	}
 	if st.MaxDelayCount > 0 && okCount.Load() >= int64(st.MaxDelayCount) {
-		fmt.Printf("已满足最大延迟测试个数，跳过剩下延迟测试，符合个数：%d \n", okCount.Load())
+		st.printf("已满足最大延迟测试个数，跳过剩下延迟测试，符合个数：%d \n", okCount.Load())
+	}
+
+	resultChan := make(chan Result, len(results))
+	for _, result := range results {
+		resultChan <- result
 	}
+	close(resultChan)
 	return resultChan
 }
 
-func (st *CFSpeedTest) TestTCP(ipPair IpPair) (*Result, error) {
+// TestDelayMulti 对同一个IP测试DelayTestCount次延迟，统计延迟分布、抖动和丢包率
+func (st *CFSpeedTest) TestDelayMulti(ctx context.Context, ipPair IpPair) (*Result, error) {
+	count := max(st.DelayTestCount, 1)
+	var result *Result
+	var lastErr error
+	var samples []time.Duration
+	for i := 0; i < count; i++ {
+		res, err := st.testDelaySingle(ctx, ipPair)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if result == nil {
+			result = res
+		}
+		samples = append(samples, res.TCPDuration)
+	}
+	if result == nil {
+		return nil, lastErr
+	}
+	result.setDelayStats(samples, count)
+	return result, nil
+}
+
+// testDelaySingle 根据延迟测试类型测试一次延迟
+func (st *CFSpeedTest) testDelaySingle(ctx context.Context, ipPair IpPair) (*Result, error) {
+	switch st.DelayTestType {
+	case 0:
+		return st.TestDelayOnce(ctx, ipPair)
+	case 1:
+		return st.TestTCP(ctx, ipPair)
+	case 2:
+		return st.TestDelayOnceH3(ctx, ipPair)
+	default:
+		return nil, fmt.Errorf("不支持的延迟测试类型: %d", st.DelayTestType)
+	}
+}
+
+func (st *CFSpeedTest) TestTCP(ctx context.Context, ipPair IpPair) (*Result, error) {
 	dialer := &net.Dialer{
 		Timeout:   timeout,
 		KeepAlive: 0,
 	}
 	start := time.Now()
-	conn, err := dialer.Dial("tcp", net.JoinHostPort(ipPair.ip, strconv.Itoa(ipPair.port)))
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ipPair.IP, strconv.Itoa(ipPair.Port)))
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 
 	tcpDuration := time.Since(start)
-	return &Result{ipPair.ip, ipPair.port, "", "", "", fmt.Sprintf("%d", tcpDuration.Milliseconds()), tcpDuration}, nil
+	return &Result{IP: ipPair.IP, Port: ipPair.Port, Latency: fmt.Sprintf("%d", tcpDuration.Milliseconds()), TCPDuration: tcpDuration}, nil
 }
 
-func (st *CFSpeedTest) TestDelayOnce(ipPair IpPair) (*Result, error) {
-	delayResult, err := st.TestDelayUseH1(ipPair)
+func (st *CFSpeedTest) TestDelayOnce(ctx context.Context, ipPair IpPair) (*Result, error) {
+	delayResult, err := st.TestDelayUseH1(ctx, ipPair)
 	if err != nil {
 		return nil, err
 	}
+	return st.parseTrace(ctx, ipPair, delayResult)
+}
+
+// TestDelayOnceH3 使用HTTP/3(QUIC)测试延迟，QuicPort不为0时使用该UDP端口
+func (st *CFSpeedTest) TestDelayOnceH3(ctx context.Context, ipPair IpPair) (*Result, error) {
+	quicPair := ipPair
+	if st.QuicPort > 0 {
+		quicPair.Port = st.QuicPort
+	}
+	delayResult, err := st.TestDelayUseH3(ctx, quicPair)
+	if err != nil {
+		return nil, err
+	}
+	return st.parseTrace(ctx, ipPair, delayResult)
+}
+
+// parseTrace 从/cdn-cgi/trace的响应中解析数据中心
+func (st *CFSpeedTest) parseTrace(ctx context.Context, ipPair IpPair, delayResult *DelayResult) (*Result, error) {
 	tcpDuration := delayResult.duration
 
 	if strings.Contains(delayResult.body, "uag=Mozilla/5.0") {
 		if matches := regexp.MustCompile(`colo=([A-Z]+)`).FindStringSubmatch(delayResult.body); len(matches) > 1 {
 			if st.TestWebSocket {
-				ok, err := st.TestWebSocketDelay(ipPair)
+				ok, err := st.TestWebSocketDelay(ctx, ipPair)
 				if !ok {
 					return nil, err
 				}
 			}
 
-			dataCenter := matches[1]
-			loc, ok := st.LocationMap[dataCenter]
-			if ok {
-				return &Result{ipPair.ip, ipPair.port, dataCenter, loc.Region, loc.City, fmt.Sprintf("%d", tcpDuration.Milliseconds()), tcpDuration}, nil
-			} else {
-				return &Result{ipPair.ip, ipPair.port, dataCenter, "", "", fmt.Sprintf("%d", tcpDuration.Milliseconds()), tcpDuration}, nil
-			}
+			result := &Result{IP: ipPair.IP, Port: ipPair.Port, Latency: fmt.Sprintf("%d", tcpDuration.Milliseconds()), TCPDuration: tcpDuration}
+			st.setLocation(result, matches[1])
+			return result, nil
 		}
 	}
 	return nil, fmt.Errorf("not match")
 }
 
-func (st *CFSpeedTest) TestWebSocketDelay(ipPair IpPair) (bool, error) {
+func (st *CFSpeedTest) TestWebSocketDelay(ctx context.Context, ipPair IpPair) (bool, error) {
 	dialer := &net.Dialer{
 		Timeout:   timeout,
 		KeepAlive: 0,
 	}
-	conn, err := dialer.Dial("tcp", net.JoinHostPort(ipPair.ip, strconv.Itoa(ipPair.port)))
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ipPair.IP, strconv.Itoa(ipPair.Port)))
 	if err != nil {
 		if st.VerboseMode {
-			fmt.Printf("connect failed, ip: %s err: %s\n", ipPair.String(), err)
+			st.printf("connect failed, ip: %s err: %s\n", ipPair.String(), err)
 		}
 		return false, err
 	}
@@ -204,9 +306,9 @@ func (st *CFSpeedTest) TestWebSocketDelay(ipPair IpPair) (bool, error) {
 	req.Header.Set("Sec-WebSocket-Version", "13")
 	req.Header.Set("Sec-WebSocket-Key", "B5ReGbZ38Rrogrznmh1TFQ==")
 	req.Close = true
-	ctx, cancel := context.WithTimeout(context.Background(), maxDuration)
+	reqCtx, cancel := context.WithTimeout(ctx, maxDuration)
 	defer cancel()
-	resp, err := client.Do(req.WithContext(ctx))
+	resp, err := client.Do(req.WithContext(reqCtx))
 	result := false
 	if err == nil && resp != nil && resp.StatusCode == 101 {
 		result = true
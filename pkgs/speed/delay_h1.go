@@ -12,16 +12,16 @@ import (
 	"time"
 )
 
-func (st *CFSpeedTest) TestDelayUseH1(ipPair IpPair) (*DelayResult, error) {
+func (st *CFSpeedTest) TestDelayUseH1(ctx context.Context, ipPair IpPair) (*DelayResult, error) {
 	dialer := &net.Dialer{
 		Timeout:   timeout,
 		KeepAlive: 0,
 	}
 	start := time.Now()
-	conn, err := dialer.Dial("tcp", net.JoinHostPort(ipPair.ip, strconv.Itoa(ipPair.port)))
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ipPair.IP, strconv.Itoa(ipPair.Port)))
 	if err != nil {
 		if st.VerboseMode {
-			fmt.Printf("connect failed, ip: %s err: %s\n", ipPair.String(), err)
+			st.printf("connect failed, ip: %s err: %s\n", ipPair.String(), err)
 		}
 		return nil, err
 	}
@@ -47,12 +47,12 @@ func (st *CFSpeedTest) TestDelayUseH1(ipPair IpPair) (*DelayResult, error) {
 	// 添加用户代理
 	req.Header.Set("User-Agent", UA)
 	req.Close = true
-	ctx, cancel := context.WithTimeout(context.Background(), maxDuration)
+	reqCtx, cancel := context.WithTimeout(ctx, maxDuration)
 	defer cancel()
-	resp, err := client.Do(req.WithContext(ctx))
+	resp, err := client.Do(req.WithContext(reqCtx))
 	if err != nil {
 		if st.VerboseMode {
-			fmt.Printf("http request failed, ip: %s err: %s\n", ipPair.String(), err)
+			st.printf("http request failed, ip: %s err: %s\n", ipPair.String(), err)
 		}
 		return nil, err
 	}
@@ -11,10 +11,18 @@ import (
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
-func (st *CFSpeedTest) TestDelayUseH3(ipPair IpPair) (*DelayResult, error) {
+// h3URL QUIC总是使用TLS，不管EnableTLS和地址中的协议都使用https
+func h3URL(u string) string {
+	u = strings.TrimPrefix(u, "http://")
+	u = strings.TrimPrefix(u, "https://")
+	return "https://" + u
+}
+
+func (st *CFSpeedTest) TestDelayUseH3(ctx context.Context, ipPair IpPair) (*DelayResult, error) {
 	start := time.Now()
 	tlsConf := &tls.Config{
 		InsecureSkipVerify: true,
@@ -25,13 +33,19 @@ func (st *CFSpeedTest) TestDelayUseH3(ipPair IpPair) (*DelayResult, error) {
 		Tracer: qlog.DefaultTracer,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	conn, err := quic.DialAddrEarly(ctx, net.JoinHostPort(ipPair.ip, strconv.Itoa(ipPair.port)), tlsConf, quicConf)
+	conn, err := quic.DialAddrEarly(dialCtx, net.JoinHostPort(ipPair.IP, strconv.Itoa(ipPair.Port)), tlsConf, quicConf)
 	if err != nil {
 		return nil, fmt.Errorf("connect err, %s", err)
 	}
 	defer conn.CloseWithError(0, "")
+	// 等待握手完成，使握手耗时与TCP建连耗时可比
+	select {
+	case <-conn.HandshakeComplete():
+	case <-dialCtx.Done():
+		return nil, fmt.Errorf("handshake err, %s", dialCtx.Err())
+	}
 	tcpDuration := time.Since(start)
 	start = time.Now()
 
@@ -47,18 +61,18 @@ func (st *CFSpeedTest) TestDelayUseH3(ipPair IpPair) (*DelayResult, error) {
 		Transport: roundTripper,
 	}
 
-	requestURL := st.GetDelayTestURL()
+	requestURL := h3URL(st.GetDelayTestURL())
 	req, _ := http.NewRequest("GET", requestURL, nil)
 
 	// 添加用户代理
 	req.Header.Set("User-Agent", UA)
 	req.Close = true
-	ctx2, cancel2 := context.WithTimeout(context.Background(), maxDuration)
+	reqCtx, cancel2 := context.WithTimeout(ctx, maxDuration)
 	defer cancel2()
-	resp, err := client.Do(req.WithContext(ctx2))
+	resp, err := client.Do(req.WithContext(reqCtx))
 	if err != nil {
 		if st.VerboseMode {
-			fmt.Printf("http request failed, ip: %s err: %s\n", ipPair.String(), err)
+			st.printf("http request failed, ip: %s err: %s\n", ipPair.String(), err)
 		}
 		return nil, err
 	}
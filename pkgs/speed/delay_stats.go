@@ -0,0 +1,49 @@
+package speed
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// setDelayStats 根据多次测试的延迟样本计算延迟分布，total为测试次数，失败的次数计为丢包
+func (r *Result) setDelayStats(samples []time.Duration, total int) {
+	if len(samples) == 0 || total <= 0 {
+		return
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	avg := sum / time.Duration(len(sorted))
+
+	var variance float64
+	for _, d := range sorted {
+		diff := float64(d - avg)
+		variance += diff * diff
+	}
+	variance /= float64(len(sorted))
+
+	r.TCPDuration = avg
+	r.Latency = fmt.Sprintf("%d", avg.Milliseconds())
+	r.MinDelay = sorted[0]
+	r.MedianDelay = percentile(sorted, 50)
+	r.P95Delay = percentile(sorted, 95)
+	r.Jitter = time.Duration(math.Sqrt(variance))
+	r.Loss = float64(total-len(samples)) / float64(total) * 100
+}
+
+// percentile 使用最近秩法计算已排序样本的百分位数
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+// formatMs 将时长格式化为毫秒，保留两位小数
+func formatMs(d time.Duration) string {
+	return fmt.Sprintf("%.2f", float64(d)/float64(time.Millisecond))
+}
@@ -0,0 +1,25 @@
+package speed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetDelayStats(t *testing.T) {
+	ms := time.Millisecond
+	r := &Result{}
+	r.setDelayStats([]time.Duration{40 * ms, 10 * ms, 30 * ms, 20 * ms}, 5)
+
+	if r.TCPDuration != 25*ms || r.Latency != "25" {
+		t.Errorf("avg = %s, latency = %s", r.TCPDuration, r.Latency)
+	}
+	if r.MinDelay != 10*ms || r.MedianDelay != 20*ms || r.P95Delay != 40*ms {
+		t.Errorf("min = %s, median = %s, p95 = %s", r.MinDelay, r.MedianDelay, r.P95Delay)
+	}
+	if want := time.Duration(11180339); r.Jitter != want {
+		t.Errorf("jitter = %d, want %d", r.Jitter, want)
+	}
+	if r.Loss != 20 {
+		t.Errorf("loss = %.2f, want 20", r.Loss)
+	}
+}
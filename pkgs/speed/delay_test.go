@@ -1,21 +1,156 @@
 package speed
 
 import (
-	"fmt"
+	"context"
+	"io"
+	"net"
 	"testing"
+
+	"github.com/jackrun123/cfiptest/pkgs/edgetest"
 )
 
-func TestCFSpeedTest_TestDelayOnce(t *testing.T) {
+// newEdge 启动模拟的边缘节点，测试结束时自动关闭
+func newEdge(t *testing.T, cfg edgetest.Config) *edgetest.Server {
+	t.Helper()
+	edge, err := edgetest.NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(edge.Close)
+	return edge
+}
 
+// newEdgeTest 返回连接模拟边缘节点的测试配置
+func newEdgeTest() *CFSpeedTest {
 	st := &CFSpeedTest{
 		EnableTLS:        true,
 		SpeedTestTimeout: 5,
-		MinSpeed:         4,
-		DelayTestURL:     "www.visa.com.hk",
-		TestWebSocket:    true,
+		DelayTestURL:     "edge.test",
+		SpeedTestURL:     "edge.test/__down?bytes=1000000",
+		MaxThread:        4,
+		MinSpeed:         0,
+		Stdout:           io.Discard,
+	}
+	st.prepare()
+	return st
+}
+
+// closedPort 返回一个没有监听的本地端口
+func closedPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return port
+}
+
+func TestCFSpeedTest_TestDelayOnce(t *testing.T) {
+	edge := newEdge(t, edgetest.Config{Colo: "NRT"})
+	st := newEdgeTest()
+	st.TestWebSocket = true
+
+	result, err := st.TestDelayOnce(context.Background(), IpPair{IP: edge.IP, Port: edge.Port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DataCenter != "NRT" || result.City != "Tokyo" || result.Region != "Asia Pacific" {
+		t.Errorf("TestDelayOnce() = %+v, want NRT Tokyo", result)
+	}
+	if result.TCPDuration <= 0 {
+		t.Errorf("TCPDuration = %s", result.TCPDuration)
+	}
+	if edge.Hits("/ws") != 1 {
+		t.Errorf("websocket hits = %d, want 1", edge.Hits("/ws"))
+	}
+}
+
+func TestCFSpeedTest_TestDelayOnceRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  edgetest.Config
+	}{
+		{"no trace", edgetest.Config{NoTrace: true}},
+		{"no websocket", edgetest.Config{NoWS: true}},
+	}
+	for _, tt := range tests {
+		edge := newEdge(t, tt.cfg)
+		st := newEdgeTest()
+		st.TestWebSocket = true
+		if result, err := st.TestDelayOnce(context.Background(), IpPair{IP: edge.IP, Port: edge.Port}); err == nil || result != nil {
+			t.Errorf("%s: TestDelayOnce() = %v, %v, want error", tt.name, result, err)
+		}
+	}
+}
+
+func TestCFSpeedTest_TestDelayOnceH3(t *testing.T) {
+	edge := newEdge(t, edgetest.Config{Colo: "SIN", HTTP3: true})
+	st := newEdgeTest()
+
+	result, err := st.TestDelayOnceH3(context.Background(), IpPair{IP: edge.IP, Port: edge.Port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DataCenter != "SIN" {
+		t.Errorf("DataCenter = %s, want SIN", result.DataCenter)
+	}
+}
+
+func TestCFSpeedTest_TestDelayOnceH3NoTLS(t *testing.T) {
+	edge := newEdge(t, edgetest.Config{Colo: "SIN", HTTP3: true})
+	st := newEdgeTest()
+	st.EnableTLS = false
+	st.DelayTestType = 2
+
+	// -tls=false只影响TCP的测试，HTTP/3仍然使用https
+	result, err := st.TestDelayOnceH3(context.Background(), IpPair{IP: edge.IP, Port: edge.Port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DataCenter != "SIN" {
+		t.Errorf("DataCenter = %s, want SIN", result.DataCenter)
+	}
+}
+
+func TestCFSpeedTest_TestDelay(t *testing.T) {
+	hkg := newEdge(t, edgetest.Config{Colo: "HKG"})
+	lax := newEdge(t, edgetest.Config{Colo: "LAX"})
+	st := newEdgeTest()
+	st.DelayTestCount = 3
+	st.FilterIATA = "HKG"
+	st.prepare()
+
+	ips := NewSliceIterator([]IpPair{
+		{IP: hkg.IP, Port: hkg.Port},
+		{IP: lax.IP, Port: lax.Port},
+		{IP: "127.0.0.1", Port: closedPort(t)},
+	})
+	resultChan := st.TestDelay(context.Background(), ips)
+	if len(resultChan) != 1 {
+		t.Fatalf("TestDelay() found %d results, want 1", len(resultChan))
+	}
+	result := <-resultChan
+	if result.DataCenter != "HKG" || result.Port != hkg.Port {
+		t.Errorf("TestDelay() = %+v, want HKG", result)
+	}
+	if result.Loss != 0 || result.MinDelay > result.P95Delay {
+		t.Errorf("loss = %.2f, min = %s, p95 = %s", result.Loss, result.MinDelay, result.P95Delay)
 	}
+	if hkg.Hits("/cdn-cgi/trace") != 3 {
+		t.Errorf("trace hits = %d, want 3", hkg.Hits("/cdn-cgi/trace"))
+	}
+}
+
+func TestCFSpeedTest_TestTCP(t *testing.T) {
+	edge := newEdge(t, edgetest.Config{})
+	st := newEdgeTest()
 
-	locationMap := st.GetLocationMap()
-	result, err := st.TestDelayOnce(IpPair{ip: "8.212.26.41", port: 443}, locationMap)
-	fmt.Println(result, err)
+	if _, err := st.TestTCP(context.Background(), IpPair{IP: edge.IP, Port: edge.Port}); err != nil {
+		t.Error(err)
+	}
+	if _, err := st.TestTCP(context.Background(), IpPair{IP: "127.0.0.1", Port: closedPort(t)}); err == nil {
+		t.Error("TestTCP() to closed port should fail")
+	}
 }
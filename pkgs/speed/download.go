@@ -1,8 +1,10 @@
 package speed
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"sort"
@@ -13,17 +15,35 @@ import (
 	"time"
 )
 
-func (st *CFSpeedTest) TestDownload(resultChan chan Result) []*SpeedTestResult {
+func (st *CFSpeedTest) TestDownload(ctx context.Context, resultChan chan Result) []*SpeedTestResult {
 	var results []*SpeedTestResult
-	if st.SpeedTestThread > 0 {
-		fmt.Printf("开始测速，待测速：%d\n", len(resultChan))
+	// 已取消时直接返回延迟测试的结果
+	if st.SpeedTestThread > 0 && ctx.Err() == nil {
+		results = []*SpeedTestResult{}
+		// 从进度文件继续测试时，恢复已完成的测速结果并跳过已测速的IP
+		if cp := st.checkpoint; cp != nil {
+			results, resultChan = cp.restoreDownload(resultChan)
+			for _, result := range results {
+				st.emitResult(result, "speed")
+			}
+			if len(results) >= st.MaxSpeedTestCount {
+				resultChan = make(chan Result)
+				close(resultChan)
+			}
+		}
+		st.printf("开始测速，待测速：%d\n", len(resultChan))
 		var wg2 sync.WaitGroup
 		wg2.Add(st.SpeedTestThread)
 		count := atomic.Int64{}
 		okCount := atomic.Int64{}
+		okCount.Store(int64(len(results)))
 		mu := sync.Mutex{}
 		total := len(resultChan)
-		results = []*SpeedTestResult{}
+		// 每个数据中心符合条件的IP个数，用于限制每个数据中心的IP个数
+		coloCount := make(map[string]int)
+		for _, result := range results {
+			coloCount[result.DataCenter]++
+		}
 		thread := make(chan struct{}, st.MaxThread)
 		for i := 0; i < st.SpeedTestThread; i++ {
 			thread <- struct{}{}
@@ -33,36 +53,83 @@ func (st *CFSpeedTest) TestDownload(resultChan chan Result) []*SpeedTestResult {
 					wg2.Done()
 				}()
 				for res := range resultChan {
+					if ctx.Err() != nil {
+						break
+					}
 					count.Add(1)
-					downloadSpeed, col, err := st.getDownloadSpeed(res.ip, res.port)
-					if res.dataCenter == "" && col != "" {
-						if loc, ok := st.LocationMap[col]; ok {
-							res.dataCenter = col
-							res.region = loc.Region
-							res.city = loc.City
+					mu.Lock()
+					if st.coloQuotaFull(coloCount, res.DataCenter) {
+						if st.VerboseMode {
+							st.printf("[%d/%d] IP %s 数据中心 %s 已达到%d个IP的配额，跳过测速\n", count.Load(), total, net.JoinHostPort(res.IP, strconv.Itoa(res.Port)), res.DataCenter, st.ColoQuota)
+						}
+						// 跳过的IP也计入进度
+						st.showDownloadProgress(&count, &okCount, total, count.Load() >= int64(total))
+						mu.Unlock()
+						continue
+					}
+					mu.Unlock()
+					tp, col, err := st.testDownloadSpeed(ctx, res.IP, res.Port, st.MinSpeed)
+					if ctx.Err() != nil {
+						// 被取消的测速结果不完整，丢弃
+						break
+					}
+					downloadSpeed := -1.0
+					if tp != nil {
+						downloadSpeed = tp.Speed
+					}
+					if res.DataCenter == "" && col != "" {
+						if _, ok := st.LocationMap[col]; ok {
+							st.setLocation(&res, col)
+						}
+					}
+					ok := st.MinSpeed <= 0 || downloadSpeed > st.MinSpeed
+					var parallelSpeed, uploadSpeed float64
+					if ok && err == nil && st.ParallelConns > 1 {
+						parallelSpeed = st.getParallelDownloadSpeed(ctx, res.IP, res.Port)
+					}
+					if ok && err == nil && st.UploadTestURL != "" {
+						var uploadErr error
+						uploadSpeed, uploadErr = st.getUploadSpeed(ctx, res.IP, res.Port)
+						if uploadErr != nil && st.VerboseMode {
+							st.printf("IP %s 上传测速失败, err: %s\n", net.JoinHostPort(res.IP, strconv.Itoa(res.Port)), uploadErr)
 						}
 					}
 					mu.Lock()
-					if st.MinSpeed <= 0 || downloadSpeed > st.MinSpeed {
+					if ok && st.coloQuotaFull(coloCount, res.DataCenter) {
+						// 同一数据中心的其他IP已经先达到配额
+						ok = false
+					}
+					var result *SpeedTestResult
+					if ok {
+						coloCount[res.DataCenter]++
 						okCount.Add(1)
-						results = append(results, &SpeedTestResult{Result: res, downloadSpeed: downloadSpeed})
+						result = &SpeedTestResult{Result: res, DownloadSpeed: downloadSpeed, Throughput: tp, ParallelSpeed: parallelSpeed, UploadSpeed: uploadSpeed, Protocol: st.downloadProtocolName()}
+						results = append(results, result)
+						st.emitResult(result, "speed")
+					}
+					if st.checkpoint != nil && ctx.Err() == nil {
+						st.checkpoint.speedDone(net.JoinHostPort(res.IP, strconv.Itoa(res.Port)), result)
 					}
-					prefix := fmt.Sprintf("[%d/%d] IP %s ", count.Load(), total, net.JoinHostPort(res.ip, strconv.Itoa(res.port)))
+					prefix := fmt.Sprintf("[%d/%d] IP %s ", count.Load(), total, net.JoinHostPort(res.IP, strconv.Itoa(res.Port)))
 					if err != nil {
-						fmt.Printf("%s测速无效, err: %s\n", prefix, err)
+						st.printf("%s测速无效, err: %s\n", prefix, err)
 					} else {
-						fmt.Printf("%s下载速度 %.2f MB/s，延迟 %s ms，地区 %s\n", prefix, downloadSpeed, res.latency, res.city)
+						speedText := fmt.Sprintf("下载速度 %.2f MB/s，峰值 %.2f MB/s，持续 %.2f MB/s，稳定性 %.2f", downloadSpeed, tp.Peak, tp.Sustained, tp.Stability)
+						if parallelSpeed > 0 {
+							speedText += fmt.Sprintf("，%d并发下载速度 %.2f MB/s", st.ParallelConns, parallelSpeed)
+						}
+						if uploadSpeed > 0 {
+							speedText += fmt.Sprintf("，上传速度 %.2f MB/s", uploadSpeed)
+						}
+						st.printf("%s%s，延迟 %s ms，地区 %s\n", prefix, speedText, res.Latency, res.City)
 					}
 
-					currentOKCount := okCount.Load()
-					percentage := float64(count.Load()) / float64(total) * 100
-					if currentOKCount >= int64(total) || currentOKCount >= int64(st.MaxSpeedTestCount) {
-						fmt.Printf("已完成: %d/%d(%.2f%%)，符合条件：%d\n", count.Load(), total, percentage, okCount.Load())
+					finished := count.Load() >= int64(total) || okCount.Load() >= int64(st.MaxSpeedTestCount)
+					st.showDownloadProgress(&count, &okCount, total, finished)
+					mu.Unlock()
+					if finished {
 						break
-					} else {
-						fmt.Printf("已完成: %d/%d(%.2f%%)，符合条件：%d\r", count.Load(), total, percentage, okCount.Load())
 					}
-					mu.Unlock()
 				}
 			}()
 		}
@@ -73,20 +140,119 @@ func (st *CFSpeedTest) TestDownload(resultChan chan Result) []*SpeedTestResult {
 		}
 	}
 
-	if st.SpeedTestThread > 0 {
-		sort.Slice(results, func(i, j int) bool {
-			return results[i].downloadSpeed > results[j].downloadSpeed
-		})
-	} else {
-		sort.Slice(results, func(i, j int) bool {
-			return results[i].Result.tcpDuration < results[j].Result.tcpDuration
-		})
+	st.sortResults(results)
+	return st.limitPerColo(results)
+}
+
+// showDownloadProgress 输出测速进度，finished为true时换行，否则覆盖当前行
+func (st *CFSpeedTest) showDownloadProgress(count, okCount *atomic.Int64, total int, finished bool) {
+	percentage := float64(count.Load()) / float64(total) * 100
+	end := "\r"
+	if finished {
+		end = "\n"
 	}
-	return results
+	st.printf("已完成: %d/%d(%.2f%%)，符合条件：%d%s", count.Load(), total, percentage, okCount.Load(), end)
+}
+
+// coloQuotaFull 判断数据中心符合条件的IP个数是否已达到配额，数据中心未知时不限制
+func (st *CFSpeedTest) coloQuotaFull(coloCount map[string]int, dataCenter string) bool {
+	return st.ColoQuota > 0 && dataCenter != "" && coloCount[dataCenter] >= st.ColoQuota
 }
 
-// 测速函数
-func (st *CFSpeedTest) getDownloadSpeed(ip string, port int) (float64, string, error) {
+// limitPerColo 按顺序保留每个数据中心最多ColoQuota个结果
+func (st *CFSpeedTest) limitPerColo(results []*SpeedTestResult) []*SpeedTestResult {
+	if st.ColoQuota <= 0 {
+		return results
+	}
+	coloCount := make(map[string]int)
+	limited := results[:0]
+	for _, res := range results {
+		if st.coloQuotaFull(coloCount, res.DataCenter) {
+			continue
+		}
+		coloCount[res.DataCenter]++
+		limited = append(limited, res)
+	}
+	return limited
+}
+
+// sortKeys 排序字段，less返回true表示a排在b前面
+var sortKeys = map[string]func(a, b *SpeedTestResult) bool{
+	"delay":     func(a, b *SpeedTestResult) bool { return a.TCPDuration < b.TCPDuration },
+	"min":       func(a, b *SpeedTestResult) bool { return a.MinDelay < b.MinDelay },
+	"median":    func(a, b *SpeedTestResult) bool { return a.MedianDelay < b.MedianDelay },
+	"p95":       func(a, b *SpeedTestResult) bool { return a.P95Delay < b.P95Delay },
+	"jitter":    func(a, b *SpeedTestResult) bool { return a.Jitter < b.Jitter },
+	"loss":      func(a, b *SpeedTestResult) bool { return a.Loss < b.Loss },
+	"speed":     func(a, b *SpeedTestResult) bool { return a.DownloadSpeed > b.DownloadSpeed },
+	"parallel":  func(a, b *SpeedTestResult) bool { return a.ParallelSpeed > b.ParallelSpeed },
+	"upload":    func(a, b *SpeedTestResult) bool { return a.UploadSpeed > b.UploadSpeed },
+	"peak":      func(a, b *SpeedTestResult) bool { return a.Throughput.getPeak() > b.Throughput.getPeak() },
+	"sustained": func(a, b *SpeedTestResult) bool { return a.Throughput.getSustained() > b.Throughput.getSustained() },
+	"stable":    func(a, b *SpeedTestResult) bool { return a.Throughput.StableSpeed() > b.Throughput.StableSpeed() },
+	"score":     func(a, b *SpeedTestResult) bool { return a.Score > b.Score },
+}
+
+// sortResults 计算综合评分并按SortBy排序，未指定时开启测速按稳定速度排序，否则按延迟排序
+func (st *CFSpeedTest) sortResults(results []*SpeedTestResult) {
+	st.calculateScores(results)
+	less, ok := sortKeys[st.SortBy]
+	if !ok {
+		if st.SpeedTestThread > 0 {
+			less = sortKeys["stable"]
+		} else {
+			less = sortKeys["delay"]
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return less(results[i], results[j])
+	})
+}
+
+// testDownloadSpeed 根据DownloadProtocol选择HTTP/1.1或HTTP/3测速
+// minSpeed为提前结束测速的速度下限，为0时不提前结束
+func (st *CFSpeedTest) testDownloadSpeed(ctx context.Context, ip string, port int, minSpeed float64) (*Throughput, string, error) {
+	d, err := st.openDownloadStream(ctx, ip, port)
+	if err != nil {
+		return nil, "", err
+	}
+	defer d.close()
+	return st.readSpeed(d.body, d.start, minSpeed), d.colo, nil
+}
+
+// downloadStream 已收到响应头的测速下载
+type downloadStream struct {
+	body  io.Reader
+	colo  string
+	start time.Time // 建立连接后开始发送请求的时间
+	close func()    // 关闭响应体和连接
+}
+
+// openDownloadStream 根据DownloadProtocol使用HTTP/1.1或HTTP/3发送测速请求
+func (st *CFSpeedTest) openDownloadStream(ctx context.Context, ip string, port int) (*downloadStream, error) {
+	if st.DownloadProtocol == "h3" {
+		if st.QuicPort > 0 {
+			port = st.QuicPort
+		}
+		return st.openDownloadH3(ctx, ip, port)
+	}
+	return st.openDownload(ctx, ip, port)
+}
+
+func (st *CFSpeedTest) downloadProtocolName() string {
+	if st.DownloadProtocol == "h3" {
+		return "HTTP/3"
+	}
+	return "HTTP/1.1"
+}
+
+// getSpeedTestURL 返回带协议的测速地址
+func (st *CFSpeedTest) getSpeedTestURL() string {
+	return st.withProtocol(st.SpeedTestURL)
+}
+
+// withProtocol 地址没有指定协议时根据EnableTLS补全
+func (st *CFSpeedTest) withProtocol(u string) string {
 	var protocol string
 	if st.EnableTLS {
 		protocol = "https://"
@@ -94,13 +260,16 @@ func (st *CFSpeedTest) getDownloadSpeed(ip string, port int) (float64, string, e
 		protocol = "http://"
 	}
 
-	speedTestURL := st.SpeedTestURL
-	if !strings.HasPrefix(st.SpeedTestURL, "http://") && !strings.HasPrefix(st.SpeedTestURL, "https://") {
-		speedTestURL = protocol + st.SpeedTestURL
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		return protocol + u
 	}
+	return u
+}
 
+// openDownload 使用HTTP/1.1发送测速请求
+func (st *CFSpeedTest) openDownload(ctx context.Context, ip string, port int) (*downloadStream, error) {
 	// 创建请求
-	req, _ := http.NewRequest("GET", speedTestURL, nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", st.getSpeedTestURL(), nil)
 	req.Header.Set("User-Agent", UA)
 
 	// 创建TCP连接
@@ -108,11 +277,10 @@ func (st *CFSpeedTest) getDownloadSpeed(ip string, port int) (float64, string, e
 		Timeout:   timeout,
 		KeepAlive: 0,
 	}
-	conn, err := dialer.Dial("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
 	if err != nil {
-		return -1, "", err
+		return nil, err
 	}
-	defer conn.Close()
 
 	startTime := time.Now()
 	// 创建HTTP客户端
@@ -130,53 +298,17 @@ func (st *CFSpeedTest) getDownloadSpeed(ip string, port int) (float64, string, e
 	req.Close = true
 	resp, err := client.Do(req)
 	if err != nil {
-		return -1, "", err
-	}
-	defer resp.Body.Close()
-
-	col := resp.Header.Get("Cf-Meta-Colo")
-
-	stop := make(chan bool)
-	var written int64 = 0
-
-	if st.SpeedTestTimeout > 2 {
-		// 中途检测下载速度
-		go func() {
-			ticker := time.NewTicker(2000 * time.Millisecond) // 2秒后快速检测一次
-			defer ticker.Stop()
-			select {
-			case <-ticker.C:
-				elapsed := time.Since(startTime).Seconds()
-				speed := float64(written) / elapsed / 1024 / 1024
-				if speed < 0.7*float64(st.MinSpeed) {
-					stop <- true
-				}
-			}
-		}()
-	}
-
-	// 复制响应体到/dev/null，并计算下载速度
-	buf := make([]byte, 8024)
-
-outerLoop:
-	for {
-		select {
-		case <-stop:
-			break outerLoop
-		default:
-			n, err := resp.Body.Read(buf)
-			if n > 0 {
-				written += int64(n)
-			}
-			if err != nil {
-				break outerLoop
-			}
-		}
+		conn.Close()
+		return nil, err
 	}
 
-	//written, _ := io.Copy(io.Discard, resp.Body)
-	duration := time.Since(startTime)
-	speed := float64(written) / duration.Seconds() / 1024 / 1024
-
-	return speed, col, nil
+	return &downloadStream{
+		body:  resp.Body,
+		colo:  resp.Header.Get("Cf-Meta-Colo"),
+		start: startTime,
+		close: func() {
+			resp.Body.Close()
+			conn.Close()
+		},
+	}, nil
 }
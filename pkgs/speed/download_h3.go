@@ -0,0 +1,76 @@
+package speed
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// openDownloadH3 使用HTTP/3(QUIC)发送测速请求
+func (st *CFSpeedTest) openDownloadH3(ctx context.Context, ip string, port int) (*downloadStream, error) {
+	speedTestURL := h3URL(st.getSpeedTestURL())
+	u, err := url.Parse(speedTestURL)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: true, // 跳过证书验证
+		ServerName:         u.Hostname(),
+		NextProtos:         []string{http3.NextProtoH3},
+	}
+	quicConf := &quic.Config{}
+
+	// 创建QUIC连接
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	conn, err := quic.DialAddrEarly(dialCtx, net.JoinHostPort(ip, strconv.Itoa(port)), tlsConf, quicConf)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	roundTripper := &http3.RoundTripper{
+		TLSClientConfig: tlsConf,
+		QUICConfig:      quicConf,
+		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+			return conn, nil
+		},
+	}
+
+	startTime := time.Now()
+	client := http.Client{
+		Transport: roundTripper,
+		//设置单个IP测速最长时间
+		Timeout: time.Duration(st.SpeedTestTimeout) * time.Second,
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", speedTestURL, nil)
+	req.Header.Set("User-Agent", UA)
+	closeConn := func() {
+		roundTripper.Close()
+		conn.CloseWithError(0, "")
+		cancel()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		closeConn()
+		return nil, err
+	}
+
+	return &downloadStream{
+		body:  resp.Body,
+		colo:  resp.Header.Get("Cf-Meta-Colo"),
+		start: startTime,
+		close: func() {
+			resp.Body.Close()
+			closeConn()
+		},
+	}, nil
+}
@@ -0,0 +1,48 @@
+package speed
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// getParallelDownloadSpeed 同时建立ParallelConns个连接测速，所有连接使用相同的开始时间和截止时间，
+// 返回全部连接下载的总字节数除以总时长得到的速度(MB/s)
+func (st *CFSpeedTest) getParallelDownloadSpeed(ctx context.Context, ip string, port int) float64 {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(st.SpeedTestTimeout)*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var written atomic.Int64
+	start := time.Now()
+	for i := 0; i < st.ParallelConns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, err := st.openDownloadStream(ctx, ip, port)
+			if err != nil {
+				if st.VerboseMode {
+					st.printf("IP %s 并发测速连接失败, err: %s\n", net.JoinHostPort(ip, strconv.Itoa(port)), err)
+				}
+				return
+			}
+			defer d.close()
+			// 单个连接的速度只是总速度的一部分，不提前结束，读取到结束或截止时间
+			buf := make([]byte, 8024)
+			for {
+				n, err := d.body.Read(buf)
+				written.Add(int64(n))
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	duration := time.Since(start)
+	return float64(written.Load()) / duration.Seconds() / 1024 / 1024
+}
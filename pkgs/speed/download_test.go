@@ -1,26 +1,196 @@
 package speed
 
-import "testing"
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackrun123/cfiptest/pkgs/edgetest"
+)
 
 func TestGetDownloadSpeed(t *testing.T) {
-	st := &CFSpeedTest{
-		EnableTLS:        true,
-		SpeedTestTimeout: 5,
-		MinSpeed:         4,
+	// 限速2MB/s，下载3MB约需要1.5秒
+	edge := newEdge(t, edgetest.Config{Colo: "HKG", Bandwidth: 2 << 20})
+	st := newEdgeTest()
+	st.SpeedTestURL = "edge.test/__down?bytes=3145728"
+
+	tp, col, err := st.testDownloadSpeed(context.Background(), edge.IP, edge.Port, st.MinSpeed)
+	if err != nil {
+		t.Fatalf("testDownloadSpeed() returned an error: %v", err)
+	}
+	if col != "HKG" {
+		t.Errorf("colo = %s, want HKG", col)
+	}
+	// 限速只限制上限，机器繁忙时只会更慢，因此只检查不超过限速
+	if tp.Speed <= 0 || tp.Speed > 2.2 {
+		t.Errorf("speed = %.2f MB/s, want at most 2", tp.Speed)
+	}
+	if len(tp.Samples) == 0 || tp.Peak < tp.Sustained {
+		t.Errorf("samples = %v, peak = %.2f, sustained = %.2f", tp.Samples, tp.Peak, tp.Sustained)
+	}
+
+	fast := newEdge(t, edgetest.Config{Colo: "HKG"})
+	unthrottled, _, err := st.testDownloadSpeed(context.Background(), fast.IP, fast.Port, st.MinSpeed)
+	if err != nil {
+		t.Fatal(err)
 	}
-	st.PreSetArgs()
+	if unthrottled.Speed <= tp.Speed {
+		t.Errorf("unthrottled speed %.2f MB/s should be above throttled %.2f MB/s", unthrottled.Speed, tp.Speed)
+	}
+}
 
-	ip := "172.64.155.2"
-	port := 443
+func TestGetDownloadSpeedH3(t *testing.T) {
+	edge := newEdge(t, edgetest.Config{Colo: "SIN", HTTP3: true})
+	st := newEdgeTest()
+	st.DownloadProtocol = "h3"
+
+	tp, col, err := st.testDownloadSpeed(context.Background(), edge.IP, edge.Port, st.MinSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if col != "SIN" || tp.Speed <= 0 {
+		t.Errorf("testDownloadSpeed() = %.2f MB/s, %s", tp.Speed, col)
+	}
+}
+
+func TestGetDownloadSpeedH3NoTLS(t *testing.T) {
+	edge := newEdge(t, edgetest.Config{Colo: "SIN", HTTP3: true})
+	st := newEdgeTest()
+	st.EnableTLS = false
+	st.DownloadProtocol = "h3"
+
+	tp, col, err := st.testDownloadSpeed(context.Background(), edge.IP, edge.Port, st.MinSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if col != "SIN" || tp.Speed <= 0 {
+		t.Errorf("testDownloadSpeed() = %.2f MB/s, %s", tp.Speed, col)
+	}
+}
+
+func TestGetDownloadSpeedAbortSlow(t *testing.T) {
+	edge := newEdge(t, edgetest.Config{Bandwidth: 100 << 10})
+	st := newEdgeTest()
+	st.SpeedTestURL = "edge.test/__down?bytes=10485760"
+	// 不提前结束时按限速需要100秒，超时时间足够长，只有提前结束才能很快返回
+	st.SpeedTestTimeout = 120
+
+	start := time.Now()
+	tp, _, err := st.testDownloadSpeed(context.Background(), edge.IP, edge.Port, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("slow download took %s, want early abort", elapsed)
+	}
+	if tp.Speed >= 1 {
+		t.Errorf("speed = %.2f MB/s, want below 1", tp.Speed)
+	}
+}
 
-	speed, _, err := st.getDownloadSpeed(ip, port)
+func TestGetUploadSpeed(t *testing.T) {
+	edge := newEdge(t, edgetest.Config{})
+	st := newEdgeTest()
+	st.UploadTestURL = "edge.test/__up"
+	st.UploadSize = 1
 
+	speed, err := st.getUploadSpeed(context.Background(), edge.IP, edge.Port)
 	if err != nil {
-		t.Errorf("getDownloadSpeed(%s, %d) returned an error: %v", ip, port, err)
+		t.Fatal(err)
+	}
+	if speed <= 0 || edge.Hits("/__up") != 1 {
+		t.Errorf("upload speed = %.2f, hits = %d", speed, edge.Hits("/__up"))
+	}
+}
+
+func TestTestDownload(t *testing.T) {
+	fast := newEdge(t, edgetest.Config{Colo: "HKG"})
+	slow := newEdge(t, edgetest.Config{Colo: "NRT", Bandwidth: 200 << 10})
+	st := newEdgeTest()
+	st.SpeedTestThread = 1
+	st.MaxSpeedTestCount = 10
+	st.MinSpeed = 1
+	st.ParallelConns = 2
+
+	resultChan := make(chan Result, 2)
+	resultChan <- Result{IP: slow.IP, Port: slow.Port, DataCenter: "NRT"}
+	resultChan <- Result{IP: fast.IP, Port: fast.Port, DataCenter: "HKG"}
+	close(resultChan)
+
+	results := st.TestDownload(context.Background(), resultChan)
+	if len(results) != 1 || results[0].DataCenter != "HKG" {
+		t.Fatalf("TestDownload() = %v, want only HKG", results)
+	}
+	if results[0].ParallelSpeed <= 0 || results[0].Protocol != "HTTP/1.1" {
+		t.Errorf("parallel speed = %.2f, protocol = %s", results[0].ParallelSpeed, results[0].Protocol)
 	}
+	if fast.Hits("/__down") != 3 {
+		t.Errorf("download hits = %d, want 3", fast.Hits("/__down"))
+	}
+}
 
-	expectedSpeed := 10.0 // You should replace this with the expected speed
-	if speed != expectedSpeed {
-		t.Errorf("getDownloadSpeed(%s, %d) = %f; want %f", ip, port, speed, expectedSpeed)
+func TestTestDownloadColoQuota(t *testing.T) {
+	hkg1 := newEdge(t, edgetest.Config{Colo: "HKG"})
+	hkg2 := newEdge(t, edgetest.Config{Colo: "HKG"})
+	nrt := newEdge(t, edgetest.Config{Colo: "NRT"})
+	st := newEdgeTest()
+	st.SpeedTestThread = 1
+	st.MaxSpeedTestCount = 2
+	st.ColoQuota = 1
+	log := &logBuffer{}
+	st.Stdout = log
+
+	resultChan := make(chan Result, 3)
+	resultChan <- Result{IP: hkg1.IP, Port: hkg1.Port, DataCenter: "HKG"}
+	resultChan <- Result{IP: hkg2.IP, Port: hkg2.Port, DataCenter: "HKG"}
+	resultChan <- Result{IP: nrt.IP, Port: nrt.Port, DataCenter: "NRT"}
+	close(resultChan)
+
+	results := st.TestDownload(context.Background(), resultChan)
+	if len(results) != 2 || results[0].DataCenter == results[1].DataCenter {
+		t.Fatalf("TestDownload() = %v, want one HKG and one NRT", results)
+	}
+	if hkg2.Hits("/__down") != 0 {
+		t.Errorf("second HKG ip was speed tested after reaching the quota")
+	}
+	// 跳过测速的IP也更新进度
+	if !strings.Contains(log.String(), "已完成: 2/3(66.67%)，符合条件：1") {
+		t.Errorf("progress not updated for the skipped ip:\n%s", log.String())
+	}
+}
+
+func TestLimitPerColo(t *testing.T) {
+	st := &CFSpeedTest{ColoQuota: 2}
+	var results []*SpeedTestResult
+	for _, colo := range []string{"HKG", "HKG", "NRT", "HKG", "", "", "", "NRT", "NRT"} {
+		results = append(results, &SpeedTestResult{Result: Result{DataCenter: colo}})
+	}
+	var got []string
+	for _, res := range st.limitPerColo(results) {
+		got = append(got, res.DataCenter)
+	}
+	if want := "HKG,HKG,NRT,,,,NRT"; strings.Join(got, ",") != want {
+		t.Errorf("limitPerColo() = %s, want %s", strings.Join(got, ","), want)
+	}
+}
+
+func TestGetParallelDownloadSpeed(t *testing.T) {
+	edge := newEdge(t, edgetest.Config{Bandwidth: 1 << 20})
+	st := newEdgeTest()
+	st.SpeedTestURL = "edge.test/__down?bytes=524288"
+	st.ParallelConns = 3
+
+	tp, _, err := st.testDownloadSpeed(context.Background(), edge.IP, edge.Port, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每个连接单独限速，3个连接同时下载的总速度明显高于单连接，但不会超过3个连接的带宽之和
+	speed := st.getParallelDownloadSpeed(context.Background(), edge.IP, edge.Port)
+	if speed < 1.5*tp.Speed || speed > 3.5 {
+		t.Errorf("parallel speed = %.2f MB/s, single = %.2f MB/s", speed, tp.Speed)
+	}
+	if edge.Hits("/__down") != 4 {
+		t.Errorf("download hits = %d, want 4", edge.Hits("/__down"))
 	}
 }
@@ -0,0 +1,143 @@
+package speed
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jackrun123/cfiptest/pkgs/prefixset"
+)
+
+// loadExclude 读取ExcludeFile中不测试的IP段，读取过后直接使用缓存
+func (st *CFSpeedTest) loadExclude() (*prefixset.Set, error) {
+	if st.excludeSet != nil {
+		return st.excludeSet, nil
+	}
+	file, err := os.Open(st.ExcludeFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	exclude, err := parseExclude(file)
+	if err != nil {
+		return nil, err
+	}
+	st.excludeSet = exclude
+	return exclude, nil
+}
+
+// parseExclude 解析不测试的IP段，每行一个IP或CIDR，逗号后面的内容忽略，可以直接使用IP文件。
+// 为了避免误测，格式不正确时返回错误
+func parseExclude(reader io.Reader) (*prefixset.Set, error) {
+	exclude := &prefixset.Set{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ip, _, _ := strings.Cut(line, ",")
+		ip = strings.TrimSpace(ip)
+		var prefix netip.Prefix
+		var err error
+		if strings.Contains(ip, "/") {
+			prefix, err = netip.ParsePrefix(ip)
+		} else {
+			var addr netip.Addr
+			addr, err = netip.ParseAddr(ip)
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s 不是IP或CIDR", line)
+		}
+		exclude.Add(prefix)
+	}
+	return exclude, scanner.Err()
+}
+
+// excludeRanges 从每一行中去除不测试的IP段，返回去除后的行和少测试的IP个数，无法统计时个数为-1。
+// 域名无法判断是否在排除的IP段中，保持不变
+func excludeRanges(ranges []ipRange, exclude *prefixset.Set) ([]ipRange, int64) {
+	var result []ipRange
+	var excluded int64
+	for _, r := range ranges {
+		if r.host != "" || !exclude.Overlaps(r.prefix) {
+			result = append(result, r)
+			continue
+		}
+		rest := excludeRange(r, exclude)
+		before := r.size()
+		after := int64(0)
+		for _, part := range rest {
+			size := part.size()
+			if size < 0 || after+size < after {
+				after = -1
+				break
+			}
+			after += size
+		}
+		if excluded >= 0 {
+			if before < 0 || after < 0 {
+				excluded = -1
+			} else {
+				excluded += before - after
+			}
+		}
+		result = append(result, rest...)
+	}
+	return result, excluded
+}
+
+// excludeRange 去除一行中不测试的IP段。全部测试时拆分为剩余的IP段；
+// 抽样时剩余的完整子网照常抽样，部分被排除的子网在剩余IP不超过抽样个数时全部测试，否则只在剩余的IP段中抽样
+func excludeRange(r ipRange, exclude *prefixset.Set) []ipRange {
+	rest := exclude.Subtract(r.prefix)
+	var result []ipRange
+	if r.sample <= 0 {
+		for _, prefix := range rest {
+			part := r
+			part.prefix = prefix
+			result = append(result, part)
+		}
+		return result
+	}
+
+	blockLen := r.prefix.Addr().BitLen() - r.blockBits()
+	for i := 0; i < len(rest); {
+		if rest[i].Bits() <= blockLen {
+			part := r
+			part.prefix = rest[i]
+			result = append(result, part)
+			i++
+			continue
+		}
+
+		// 同一个子网中剩余的IP段
+		block := netip.PrefixFrom(rest[i].Addr(), blockLen).Masked()
+		j := i
+		for j < len(rest) && block.Contains(rest[j].Addr()) {
+			j++
+		}
+		available := partsSize(rest[i:j])
+		if available >= 0 && available <= int64(r.sample) {
+			for _, prefix := range rest[i:j] {
+				part := r
+				part.prefix = prefix
+				part.sample = 0
+				result = append(result, part)
+			}
+		} else {
+			part := r
+			part.prefix = block
+			part.samplePrefix = blockLen
+			part.parts = slices.Clone(rest[i:j])
+			result = append(result, part)
+		}
+		i = j
+	}
+	return result
+}
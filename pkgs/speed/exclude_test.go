@@ -0,0 +1,161 @@
+package speed
+
+import (
+	"math/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackrun123/cfiptest/pkgs/prefixset"
+)
+
+func TestParseExclude(t *testing.T) {
+	exclude, err := parseExclude(strings.NewReader("# 注释\n10.0.0.0/24\n\n10.0.1.1,443\n2001:db8::/32\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{"10.0.0.9": true, "10.0.1.1": true, "10.0.1.2": false, "2001:db8::1": true, "2001:db9::1": false} {
+		if got := exclude.Contains(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("Contains(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	if _, err := parseExclude(strings.NewReader("10.0.0.0/24\nexample.com\n")); err == nil {
+		t.Error("parseExclude() should reject lines that are not IPs")
+	}
+}
+
+func TestExcludeRanges(t *testing.T) {
+	exclude := prefixset.New(netip.MustParsePrefix("10.0.0.0/25"), netip.MustParsePrefix("10.0.2.0/31"), netip.MustParsePrefix("10.1.0.0/24"), netip.MustParsePrefix("2001:db8::/126"))
+	ranges := []ipRange{
+		{prefix: netip.MustParsePrefix("10.0.0.0/23"), port: 443},
+		{prefix: netip.MustParsePrefix("10.0.2.0/30"), port: 443, sample: 3, samplePrefix: 30},
+		{prefix: netip.MustParsePrefix("10.1.0.0/22"), port: 443, sample: 1, samplePrefix: 24},
+		{prefix: netip.MustParsePrefix("2001:db8::/120"), port: 443},
+		{host: "example.com", port: 443},
+	}
+	got, excluded := excludeRanges(ranges, exclude)
+	// 128个全部测试的IP、2个小于抽样个数全部测试的IP、1个被排除子网的抽样和4个IPv6
+	if excluded != 128+1+1+4 {
+		t.Errorf("excluded = %d", excluded)
+	}
+
+	var list []string
+	for _, r := range got {
+		if r.host != "" {
+			list = append(list, r.host)
+			continue
+		}
+		s := r.prefix.String()
+		if r.sample > 0 {
+			s += ",sample"
+		}
+		list = append(list, s)
+	}
+	want := "10.0.0.128/25 10.0.1.0/24 10.0.2.2/31 10.1.1.0/24,sample 10.1.2.0/23,sample 2001:db8::4/126 2001:db8::8/125 2001:db8::10/124 2001:db8::20/123 2001:db8::40/122 2001:db8::80/121 example.com"
+	if strings.Join(list, " ") != want {
+		t.Errorf("excludeRanges() = %s, want %s", strings.Join(list, " "), want)
+	}
+}
+
+func TestParseIPsExclude(t *testing.T) {
+	dir := t.TempDir()
+	excludeFile := filepath.Join(dir, "exclude.txt")
+	if err := os.WriteFile(excludeFile, []byte("10.0.0.0/25\n10.0.1.7\n2400:cb00::/121\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st := &CFSpeedTest{DefaultPort: 443, ExcludeFile: excludeFile, Seed: 1}
+	ips, err := st.ParseIPs(strings.NewReader("10.0.0.0/24,443,sample=4,prefix=24\n10.0.1.0/24\n2400:cb00::/120\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclude, _ := st.loadExclude()
+	count := 0
+	for {
+		ip, ok := ips.Next()
+		if !ok {
+			break
+		}
+		count++
+		if exclude.Contains(netip.MustParseAddr(ip.IP)) {
+			t.Errorf("Next() = %s, which is excluded", ip.IP)
+		}
+	}
+	if count != 4+255+128 {
+		t.Errorf("count = %d, want %d", count, 4+255+128)
+	}
+
+	st = &CFSpeedTest{DefaultPort: 443, ExcludeFile: filepath.Join(dir, "missing.txt")}
+	if _, err := st.ParseIPs(strings.NewReader("10.0.0.0/24\n")); err == nil {
+		t.Error("ParseIPs() should fail when the exclude file is missing")
+	}
+}
+
+// sparseExclude 返回排除block中除keep以外全部IP的集合
+func sparseExclude(block, keep string) *prefixset.Set {
+	exclude := prefixset.New(netip.MustParsePrefix(block))
+	exclude.Remove(netip.MustParsePrefix(keep))
+	return exclude
+}
+
+func TestExcludeRangesSparse(t *testing.T) {
+	// /48的抽样子网中只剩下一个/125，抽样时不能在整个/48中随机挑选
+	exclude := sparseExclude("2001:db8::/48", "2001:db8:0:1234::8/125")
+	ranges, excluded := excludeRanges([]ipRange{{prefix: netip.MustParsePrefix("2001:db8::/48"), port: 443, sample: 2, samplePrefix: 48}}, exclude)
+	if len(ranges) != 1 || excluded != 0 {
+		t.Fatalf("excludeRanges() = %v, %d", ranges, excluded)
+	}
+
+	done := make(chan []IpPair)
+	go func() {
+		var ips []IpPair
+		it := newRangeIterator(ranges, rand.New(rand.NewSource(1)))
+		for {
+			ip, ok := it.Next()
+			if !ok {
+				break
+			}
+			ips = append(ips, ip)
+		}
+		done <- ips
+	}()
+	select {
+	case ips := <-done:
+		if len(ips) != 2 || ips[0] == ips[1] {
+			t.Errorf("Next() = %v, want 2 different ips", ips)
+		}
+		for _, ip := range ips {
+			if exclude.Contains(netip.MustParseAddr(ip.IP)) {
+				t.Errorf("Next() = %s, which is excluded", ip.IP)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sampling a sparse remainder did not finish")
+	}
+}
+
+func TestNeighbourSubnetExclude(t *testing.T) {
+	prefix := netip.MustParsePrefix("2001:db8::/64")
+	exclude := sparseExclude("2001:db8::/64", "2001:db8::8/125")
+	subnet := &neighbourSubnet{
+		prefix: prefix,
+		parts:  exclude.Subtract(prefix),
+		port:   443,
+		rnd:    rand.New(rand.NewSource(1)),
+		tested: make(map[netip.Addr]struct{}),
+	}
+	ips := subnet.pick(16)
+	if len(ips) != 8 {
+		t.Errorf("pick() returned %d ips, want 8", len(ips))
+	}
+	for _, ip := range ips {
+		if exclude.Contains(netip.MustParseAddr(ip.IP)) {
+			t.Errorf("pick() = %s, which is excluded", ip.IP)
+		}
+	}
+	if ips := subnet.pick(1); len(ips) != 0 {
+		t.Errorf("pick() = %v after the subnet was exhausted", ips)
+	}
+}
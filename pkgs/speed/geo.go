@@ -0,0 +1,121 @@
+package speed
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 地球平均半径(km)
+const earthRadius = 6371.0
+
+// GeoPoint 距离过滤的中心位置和半径
+type GeoPoint struct {
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Radius float64 `json:"radius_km"` // 半径(km)，0表示只计算距离不过滤
+}
+
+// parseNear 解析距离过滤，格式为"纬度,经度[:半径km]"或"IATA[:半径km]"
+func parseNear(near string, locationMap map[string]Location) (*GeoPoint, error) {
+	near = strings.TrimSpace(near)
+	if near == "" {
+		return nil, nil
+	}
+	place, radiusText, hasRadius := strings.Cut(near, ":")
+	point := &GeoPoint{}
+	if hasRadius {
+		radius, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(radiusText), "km"), 64)
+		if err != nil || radius < 0 {
+			return nil, fmt.Errorf("半径 %s 不正确", radiusText)
+		}
+		point.Radius = radius
+	}
+
+	if latText, lonText, ok := strings.Cut(place, ","); ok {
+		lat, err1 := strconv.ParseFloat(strings.TrimSpace(latText), 64)
+		lon, err2 := strconv.ParseFloat(strings.TrimSpace(lonText), 64)
+		if err1 != nil || err2 != nil || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+			return nil, fmt.Errorf("经纬度 %s 不正确", place)
+		}
+		point.Lat, point.Lon = lat, lon
+		return point, nil
+	}
+
+	loc, ok := locationMap[strings.ToUpper(strings.TrimSpace(place))]
+	if !ok {
+		return nil, fmt.Errorf("找不到数据中心 %s 的位置", place)
+	}
+	point.Lat, point.Lon = loc.Lat, loc.Lon
+	return point, nil
+}
+
+// distance 返回两个经纬度之间的球面距离(km)
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// parseFilterSet 解析英文逗号分隔的过滤条件，统一转为大写，为空时返回nil
+func parseFilterSet(value string) map[string]*struct{} {
+	var set map[string]*struct{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.ToUpper(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if set == nil {
+			set = make(map[string]*struct{})
+		}
+		set[item] = &struct{}{}
+	}
+	return set
+}
+
+// setLocation 设置结果的数据中心，并根据位置信息补全地区、城市、国家和距离
+func (st *CFSpeedTest) setLocation(result *Result, dataCenter string) {
+	result.DataCenter = dataCenter
+	loc, ok := st.LocationMap[dataCenter]
+	if !ok {
+		return
+	}
+	result.Region = loc.Region
+	result.City = loc.City
+	result.Country = loc.Cca2
+	if st.NearPoint != nil {
+		result.Distance = distance(st.NearPoint.Lat, st.NearPoint.Lon, loc.Lat, loc.Lon)
+	}
+}
+
+// isFiltered 判断结果的数据中心是否被过滤，同时指定多个条件时需要全部满足
+func (st *CFSpeedTest) isFiltered(result *Result) bool {
+	if st.FilterIATASet != nil && st.FilterIATASet[result.DataCenter] == nil {
+		return true
+	}
+	if st.ExcludeIATASet != nil && st.ExcludeIATASet[result.DataCenter] != nil {
+		return true
+	}
+	if st.FilterCountrySet == nil && st.FilterRegionSet == nil && (st.NearPoint == nil || st.NearPoint.Radius <= 0) {
+		return false
+	}
+
+	loc, ok := st.LocationMap[result.DataCenter]
+	if !ok {
+		// 位置未知的数据中心无法判断地理位置
+		return true
+	}
+	if st.FilterCountrySet != nil && st.FilterCountrySet[strings.ToUpper(loc.Cca2)] == nil {
+		return true
+	}
+	if st.FilterRegionSet != nil && st.FilterRegionSet[strings.ToUpper(loc.Region)] == nil {
+		return true
+	}
+	if st.NearPoint != nil && st.NearPoint.Radius > 0 && result.Distance > st.NearPoint.Radius {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package speed
+
+import (
+	"context"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseNear(t *testing.T) {
+	locationMap := map[string]Location{"HKG": {Iata: "HKG", Lat: 22.3, Lon: 113.9}}
+	tests := []struct {
+		near string
+		want *GeoPoint
+		ok   bool
+	}{
+		{"", nil, true},
+		{"35.68,139.76:1000", &GeoPoint{Lat: 35.68, Lon: 139.76, Radius: 1000}, true},
+		{"35.68, 139.76:500km", &GeoPoint{Lat: 35.68, Lon: 139.76, Radius: 500}, true},
+		{"hkg:2000", &GeoPoint{Lat: 22.3, Lon: 113.9, Radius: 2000}, true},
+		{"HKG", &GeoPoint{Lat: 22.3, Lon: 113.9}, true},
+		{"XXX:100", nil, false},
+		{"91,0:100", nil, false},
+		{"35,139:abc", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseNear(tt.near, locationMap)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseNear(%q) error = %v", tt.near, err)
+			continue
+		}
+		if tt.want == nil && got != nil || tt.want != nil && (got == nil || *got != *tt.want) {
+			t.Errorf("parseNear(%q) = %+v, want %+v", tt.near, got, tt.want)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	// 香港到东京约2900km
+	if d := distance(22.3089, 113.915, 35.7647, 140.386); math.Abs(d-2950) > 100 {
+		t.Errorf("distance(HKG, NRT) = %.0f", d)
+	}
+	if d := distance(10, 20, 10, 20); d != 0 {
+		t.Errorf("distance to self = %f", d)
+	}
+}
+
+func TestIsFiltered(t *testing.T) {
+	tests := []struct {
+		name     string
+		st       CFSpeedTest
+		filtered []string
+		kept     []string
+	}{
+		{"iata", CFSpeedTest{FilterIATA: "HKG,nrt"}, []string{"SIN", "LAX"}, []string{"HKG", "NRT"}},
+		{"exclude", CFSpeedTest{ExcludeIATA: "LAX, sjc"}, []string{"LAX", "SJC"}, []string{"HKG", "NRT"}},
+		{"include and exclude", CFSpeedTest{FilterIATA: "HKG,NRT", ExcludeIATA: "NRT"}, []string{"NRT", "SIN"}, []string{"HKG"}},
+		{"country", CFSpeedTest{FilterCountry: "jp,KR"}, []string{"HKG", "LAX"}, []string{"NRT", "ICN"}},
+		{"region", CFSpeedTest{FilterRegion: "Asia Pacific"}, []string{"LAX", "FRA"}, []string{"HKG", "SIN", "NRT"}},
+		{"near", CFSpeedTest{Near: "HKG:1000"}, []string{"NRT", "SIN", "LAX"}, []string{"HKG", "MFM"}},
+		{"near without radius", CFSpeedTest{Near: "HKG"}, nil, []string{"HKG", "LAX"}},
+		{"combined", CFSpeedTest{FilterRegion: "Asia Pacific", Near: "NRT:3000"}, []string{"SIN", "SEA"}, []string{"NRT", "HKG", "ICN"}},
+		{"unknown colo", CFSpeedTest{FilterCountry: "JP"}, []string{"ZZZ"}, nil},
+	}
+	for _, tt := range tests {
+		st := tt.st
+		st.Stdout = io.Discard
+		st.prepare()
+		check := func(colo string, want bool) {
+			result := &Result{}
+			st.setLocation(result, colo)
+			if got := st.isFiltered(result); got != want {
+				t.Errorf("%s: isFiltered(%s) = %t, want %t", tt.name, colo, got, want)
+			}
+		}
+		for _, colo := range tt.filtered {
+			check(colo, true)
+		}
+		for _, colo := range tt.kept {
+			check(colo, false)
+		}
+	}
+}
+
+func TestPrepareInvalidNear(t *testing.T) {
+	for _, near := range []string{"HKGG:1000", "HKG:abc", "91,0"} {
+		st := &CFSpeedTest{Near: near, Stdout: io.Discard}
+		if err := st.prepare(); err == nil {
+			t.Errorf("prepare() with near %q should fail", near)
+		}
+	}
+
+	// 运行时直接结束，不创建输出文件
+	st := newEdgeTest()
+	st.Near = "HKGG:1000"
+	st.IpFile = filepath.Join(t.TempDir(), "ip.txt")
+	st.OutFile = filepath.Join(t.TempDir(), "out.csv")
+	if err := os.WriteFile(st.IpFile, []byte("127.0.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st.Run(context.Background())
+	if _, err := os.Stat(st.OutFile); !os.IsNotExist(err) {
+		t.Errorf("output file should not be created, stat err = %v", err)
+	}
+
+	tester := NewTester(CFSpeedTest{Near: "HKGG:1000"})
+	if _, err := tester.Run(context.Background(), NewSliceIterator(nil), nil); err == nil {
+		t.Error("Tester.Run() with an invalid near should fail")
+	}
+}
@@ -0,0 +1,377 @@
+package speed
+
+import (
+	"math"
+	"math/rand"
+	"net/netip"
+
+	"github.com/jackrun123/cfiptest/pkgs/prefixset"
+)
+
+// 打乱顺序时使用的缓冲区大小，超过这个数量的IP只在窗口内打乱
+const shuffleWindow = 65536
+
+// IpIterator 按需逐个生成待测试的IP，避免一次性展开全部CIDR
+type IpIterator interface {
+	// Next 返回下一个待测试的IP，没有更多IP时返回false
+	Next() (IpPair, bool)
+	// Total 返回IP总数，无法统计时返回-1
+	Total() int64
+}
+
+// ipRange 对应IP文件中的一行
+type ipRange struct {
+	prefix       netip.Prefix // IP或CIDR，单个IP的前缀长度等于地址长度
+	host         string       // 无法解析为IP时原样使用，例如域名
+	port         int
+	sample       int            // 每个子网随机抽取的IP个数，0表示全部测试
+	samplePrefix int            // 抽样子网的前缀长度
+	parts        []netip.Prefix // 部分被排除的子网中剩余的IP段，抽样时只在这些IP段中挑选
+}
+
+// blockBits 返回抽样子网内主机部分的位数
+func (r *ipRange) blockBits() int {
+	bits := r.prefix.Addr().BitLen()
+	prefix := max(r.samplePrefix, r.prefix.Bits())
+	return bits - min(prefix, bits)
+}
+
+// size 返回该行包含的IP个数，超过int64范围时返回-1
+func (r *ipRange) size() int64 {
+	if r.host != "" {
+		return 1
+	}
+	bits := r.prefix.Addr().BitLen() - r.prefix.Bits()
+	if r.sample <= 0 {
+		return pow2(bits)
+	}
+
+	hostBits := r.blockBits()
+	perBlock := int64(r.sample)
+	if size := pow2(hostBits); size >= 0 && size < perBlock {
+		perBlock = size
+	}
+	blocks := pow2(bits - hostBits)
+	if blocks < 0 || blocks > math.MaxInt64/perBlock {
+		return -1
+	}
+	return blocks * perBlock
+}
+
+func (r *ipRange) iterator(rnd *rand.Rand) IpIterator {
+	if r.host != "" {
+		return &singleIterator{ip: IpPair{IP: r.host, Port: r.port}}
+	}
+	if r.sample > 0 {
+		hostBits := r.blockBits()
+		if size := pow2(hostBits); size < 0 || size > int64(r.sample) {
+			return &sampleIterator{r: r, hostBits: hostBits, rnd: rnd}
+		}
+	}
+	return &prefixIterator{r: r}
+}
+
+// sampleKey 抽样参数相同的行才能互相去重
+type sampleKey struct {
+	port, sample, samplePrefix int
+}
+
+// dedupeRanges 去除与前面的行重复的IP，重复的IP只测试一次，返回去重后的行和有重复的行数。
+// 全部测试的行去掉已经测试过的部分，抽样的行只在被前面的行完全包含时去除
+func dedupeRanges(ranges []ipRange) ([]ipRange, int) {
+	var result []ipRange
+	dup := 0
+	hosts := make(map[IpPair]bool)
+	full := make(map[int]*prefixset.Set)          // 端口到全部测试的IP段
+	sampled := make(map[sampleKey]*prefixset.Set) // 抽样参数到抽样的IP段
+	for _, r := range ranges {
+		if r.host != "" {
+			key := IpPair{IP: r.host, Port: r.port}
+			if hosts[key] {
+				dup++
+				continue
+			}
+			hosts[key] = true
+			result = append(result, r)
+			continue
+		}
+
+		if full[r.port] == nil {
+			full[r.port] = &prefixset.Set{}
+		}
+		if r.sample > 0 {
+			key := sampleKey{r.port, r.sample, r.samplePrefix}
+			if sampled[key] == nil {
+				sampled[key] = &prefixset.Set{}
+			}
+			if full[r.port].ContainsPrefix(r.prefix) || sampled[key].ContainsPrefix(r.prefix) {
+				dup++
+				continue
+			}
+			sampled[key].Add(r.prefix)
+			result = append(result, r)
+			continue
+		}
+
+		rest := full[r.port].Subtract(r.prefix)
+		full[r.port].Add(r.prefix)
+		if len(rest) == 1 && rest[0] == r.prefix.Masked() {
+			result = append(result, r)
+			continue
+		}
+		dup++
+		for _, prefix := range rest {
+			part := r
+			part.prefix = prefix
+			result = append(result, part)
+		}
+	}
+	return result, dup
+}
+
+// pow2 返回2的n次方，超过int64范围时返回-1
+func pow2(n int) int64 {
+	if n >= 63 {
+		return -1
+	}
+	return 1 << n
+}
+
+// rangeIterator 按文件顺序逐个展开每一行
+type rangeIterator struct {
+	ranges []ipRange
+	rnd    *rand.Rand // 抽样使用的随机数，种子相同时生成的IP顺序相同
+	idx    int
+	cur    IpIterator
+}
+
+func newRangeIterator(ranges []ipRange, rnd *rand.Rand) *rangeIterator {
+	return &rangeIterator{ranges: ranges, rnd: rnd}
+}
+
+func (it *rangeIterator) Next() (IpPair, bool) {
+	for it.idx < len(it.ranges) {
+		if it.cur == nil {
+			it.cur = it.ranges[it.idx].iterator(it.rnd)
+		}
+		if ip, ok := it.cur.Next(); ok {
+			return ip, true
+		}
+		it.cur = nil
+		it.idx++
+	}
+	return IpPair{}, false
+}
+
+func (it *rangeIterator) Total() int64 {
+	var total int64
+	for i := range it.ranges {
+		size := it.ranges[i].size()
+		if size < 0 || total+size < total {
+			return -1
+		}
+		total += size
+	}
+	return total
+}
+
+// singleIterator 只返回一个IP
+type singleIterator struct {
+	ip   IpPair
+	done bool
+}
+
+func (it *singleIterator) Next() (IpPair, bool) {
+	if it.done {
+		return IpPair{}, false
+	}
+	it.done = true
+	return it.ip, true
+}
+
+func (it *singleIterator) Total() int64 {
+	return 1
+}
+
+// prefixIterator 按顺序展开网段内的全部IP
+type prefixIterator struct {
+	r    *ipRange
+	next netip.Addr
+	done bool
+}
+
+func (it *prefixIterator) Next() (IpPair, bool) {
+	if it.done {
+		return IpPair{}, false
+	}
+	if !it.next.IsValid() {
+		it.next = it.r.prefix.Masked().Addr()
+	}
+	addr := it.next
+	// 到达网段末尾时结束
+	if n := addr.Next(); n.IsValid() && it.r.prefix.Contains(n) {
+		it.next = n
+	} else {
+		it.done = true
+	}
+	return IpPair{IP: addr.String(), Port: it.r.port}, true
+}
+
+func (it *prefixIterator) Total() int64 {
+	return it.r.size()
+}
+
+// sampleIterator 依次遍历网段内的每个子网，每个子网随机抽取若干个不重复的IP
+type sampleIterator struct {
+	r        *ipRange
+	hostBits int
+	rnd      *rand.Rand
+	block    netip.Addr              // 当前子网的起始地址
+	picked   map[netip.Addr]struct{} // 当前子网已抽取的IP
+	done     bool
+}
+
+func (it *sampleIterator) Next() (IpPair, bool) {
+	if it.done {
+		return IpPair{}, false
+	}
+	if !it.block.IsValid() {
+		it.block = it.r.prefix.Masked().Addr()
+		it.picked = make(map[netip.Addr]struct{}, it.r.sample)
+	}
+	if len(it.picked) >= it.r.sample {
+		next, ok := nextBlock(it.block, it.hostBits)
+		if !ok || !it.r.prefix.Contains(next) {
+			it.done = true
+			return IpPair{}, false
+		}
+		it.block = next
+		clear(it.picked)
+	}
+
+	addr := it.randomAddr()
+	for {
+		if _, ok := it.picked[addr]; !ok {
+			break
+		}
+		addr = it.randomAddr()
+	}
+	it.picked[addr] = struct{}{}
+	return IpPair{IP: addr.String(), Port: it.r.port}, true
+}
+
+func (it *sampleIterator) Total() int64 {
+	return it.r.size()
+}
+
+// randomAddr 在当前子网中随机挑选一个IP，设置了parts时只在parts中挑选
+func (it *sampleIterator) randomAddr() netip.Addr {
+	if len(it.r.parts) > 0 {
+		return randomAddr(it.rnd, it.r.parts)
+	}
+	return randomHost(it.rnd, it.block, it.hostBits)
+}
+
+// randomAddr 在多个IP段中均匀随机挑选一个IP，先按IP段的大小加权选择IP段，再随机填充主机位
+func randomAddr(rnd *rand.Rand, parts []netip.Prefix) netip.Addr {
+	part := parts[0]
+	if len(parts) > 1 {
+		total := 0.0
+		for _, p := range parts {
+			total += math.Exp2(float64(p.Addr().BitLen() - p.Bits()))
+		}
+		x := rnd.Float64() * total
+		for _, p := range parts {
+			part = p
+			if x -= math.Exp2(float64(p.Addr().BitLen() - p.Bits())); x < 0 {
+				break
+			}
+		}
+	}
+	return randomHost(rnd, part.Masked().Addr(), part.Addr().BitLen()-part.Bits())
+}
+
+// partsSize 返回多个IP段包含的IP个数，超过int64范围时返回-1
+func partsSize(parts []netip.Prefix) int64 {
+	var total int64
+	for _, p := range parts {
+		size := pow2(p.Addr().BitLen() - p.Bits())
+		if size < 0 || total+size < total {
+			return -1
+		}
+		total += size
+	}
+	return total
+}
+
+// nextBlock 返回下一个主机位数为hostBits的子网起始地址，溢出时返回false
+func nextBlock(block netip.Addr, hostBits int) (netip.Addr, bool) {
+	b := block.AsSlice()
+	i := len(b) - 1 - hostBits/8
+	carry := uint(1) << (hostBits % 8)
+	for ; i >= 0 && carry > 0; i-- {
+		sum := uint(b[i]) + carry
+		b[i] = byte(sum)
+		carry = sum >> 8
+	}
+	if carry > 0 {
+		return netip.Addr{}, false
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr, true
+}
+
+// randomHost 随机填充子网起始地址的主机位
+func randomHost(rnd *rand.Rand, block netip.Addr, hostBits int) netip.Addr {
+	b := block.AsSlice()
+	for i := len(b) - 1; i >= 0 && hostBits > 0; i-- {
+		n := min(hostBits, 8)
+		b[i] |= byte(rnd.Intn(256)) & byte(1<<n-1)
+		hostBits -= n
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// shuffleIterator 在固定大小的窗口内随机打乱顺序，内存占用不随IP总数增长
+type shuffleIterator struct {
+	src    IpIterator
+	rnd    *rand.Rand
+	buf    []IpPair
+	filled bool
+}
+
+func newShuffleIterator(src IpIterator, rnd *rand.Rand) *shuffleIterator {
+	return &shuffleIterator{src: src, rnd: rnd}
+}
+
+func (it *shuffleIterator) Next() (IpPair, bool) {
+	if !it.filled {
+		it.filled = true
+		for len(it.buf) < shuffleWindow {
+			ip, ok := it.src.Next()
+			if !ok {
+				break
+			}
+			it.buf = append(it.buf, ip)
+		}
+	}
+	if len(it.buf) == 0 {
+		return IpPair{}, false
+	}
+
+	i := it.rnd.Intn(len(it.buf))
+	ip := it.buf[i]
+	if next, ok := it.src.Next(); ok {
+		it.buf[i] = next
+	} else {
+		last := len(it.buf) - 1
+		it.buf[i] = it.buf[last]
+		it.buf = it.buf[:last]
+	}
+	return ip, true
+}
+
+func (it *shuffleIterator) Total() int64 {
+	return it.src.Total()
+}
@@ -0,0 +1,186 @@
+package speed
+
+import (
+	"math/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadIPs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ip.txt")
+	content := "1.0.0.1,2053\n1.0.0.0/30\n\n2400:cb00::/96,8443\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	st := &CFSpeedTest{DefaultPort: 443}
+	ips, err := st.readIPs(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := ips.Total(); total != 1+4+1<<32 {
+		t.Errorf("Total() = %d", total)
+	}
+
+	want := []string{"1.0.0.1:2053", "1.0.0.0:443", "1.0.0.1:443", "1.0.0.2:443", "1.0.0.3:443", "[2400:cb00::]:8443", "[2400:cb00::1]:8443"}
+	for _, w := range want {
+		ip, ok := ips.Next()
+		if !ok {
+			t.Fatalf("Next() ended early, want %s", w)
+		}
+		if ip.String() != w {
+			t.Errorf("Next() = %s, want %s", ip.String(), w)
+		}
+	}
+}
+
+func TestShuffleIterator(t *testing.T) {
+	ips := newShuffleIterator(newRangeIterator([]ipRange{{prefix: netip.MustParsePrefix("10.0.0.0/24"), port: 443}}, rand.New(rand.NewSource(1))), rand.New(rand.NewSource(1)))
+	seen := map[string]bool{}
+	for {
+		ip, ok := ips.Next()
+		if !ok {
+			break
+		}
+		if seen[ip.IP] {
+			t.Fatalf("duplicate ip %s", ip.IP)
+		}
+		seen[ip.IP] = true
+	}
+	if len(seen) != 256 {
+		t.Errorf("got %d ips, want 256", len(seen))
+	}
+}
+
+func TestSampleIterator(t *testing.T) {
+	st := &CFSpeedTest{DefaultPort: 443, SampleCount: 2, SamplePrefix: 24, SamplePrefix6: 48}
+	tests := []struct {
+		line  string
+		total int64
+	}{
+		{"104.16.0.0/13", 2 << 11},
+		{"104.16.0.0/13,443,sample=3,prefix=16", 3 << 3},
+		{"104.16.0.0/31", 2},
+		{"1.1.1.0/30,443,sample=8", 4},
+		{"2400:cb00::/96,,sample=1,prefix=120", 1 << 24},
+	}
+	for _, tt := range tests {
+		r, err := st.parseIPLine(tt.line)
+		if err != nil {
+			t.Fatalf("parseIPLine(%s) error: %v", tt.line, err)
+		}
+		it := newRangeIterator([]ipRange{*r}, rand.New(rand.NewSource(1)))
+		if total := it.Total(); total != tt.total {
+			t.Errorf("%s Total() = %d, want %d", tt.line, total, tt.total)
+		}
+		if tt.total > 1<<16 {
+			continue
+		}
+
+		seen := map[string]bool{}
+		for {
+			ip, ok := it.Next()
+			if !ok {
+				break
+			}
+			addr := netip.MustParseAddr(ip.IP)
+			if !r.prefix.Contains(addr) || seen[ip.IP] {
+				t.Fatalf("%s got unexpected ip %s", tt.line, ip.IP)
+			}
+			seen[ip.IP] = true
+		}
+		if int64(len(seen)) != tt.total {
+			t.Errorf("%s got %d ips, want %d", tt.line, len(seen), tt.total)
+		}
+	}
+}
+
+func TestParseIPsDedupe(t *testing.T) {
+	content := `1.0.0.2
+1.0.0.0/30
+1.0.0.2,443
+1.0.0.2,2053
+1.0.0.0/31
+example.com
+example.com
+10.0.0.0/16,443,sample=1,prefix=24
+10.0.1.0/24,443,sample=1,prefix=24
+10.0.1.0/24,443,sample=2,prefix=24
+`
+	st := &CFSpeedTest{DefaultPort: 443}
+	ips, err := st.ParseIPs(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := ips.Total(); total != 1+3+1+1+256+2 {
+		t.Errorf("Total() = %d", total)
+	}
+
+	var got []string
+	for {
+		ip, ok := ips.Next()
+		if !ok {
+			break
+		}
+		if !strings.HasPrefix(ip.IP, "10.") {
+			got = append(got, ip.String())
+		}
+	}
+	want := "1.0.0.2:443 1.0.0.0:443 1.0.0.1:443 1.0.0.3:443 1.0.0.2:2053 example.com:443"
+	if strings.Join(got, " ") != want {
+		t.Errorf("Next() = %s, want %s", strings.Join(got, " "), want)
+	}
+}
+
+func TestReadIPsStdin(t *testing.T) {
+	st := &CFSpeedTest{DefaultPort: 443, IpFile: "-", Stdin: strings.NewReader("1.0.0.1,2053\n1.0.0.0/31\n")}
+	// 计算进度文件摘要时已经读取过标准输入，生成IP时使用缓存的内容
+	fp1, err := st.fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp2, _ := st.fingerprint()
+	if fp1 != fp2 {
+		t.Errorf("fingerprint() changed after reading stdin")
+	}
+
+	ips, err := st.readIPs(st.IpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		ip, ok := ips.Next()
+		if !ok {
+			break
+		}
+		got = append(got, ip.String())
+	}
+	if want := "1.0.0.1:2053 1.0.0.0:443 1.0.0.1:443"; strings.Join(got, " ") != want {
+		t.Errorf("Next() = %s, want %s", strings.Join(got, " "), want)
+	}
+}
+
+func TestReadIPsPrefixes(t *testing.T) {
+	st := &CFSpeedTest{DefaultPort: 8443, IpFile: "missing.txt", SampleCount: 1, SamplePrefix: 24,
+		Prefixes: []netip.Prefix{netip.MustParsePrefix("104.16.0.0/22"), netip.MustParsePrefix("1.1.1.1/32")}}
+	ips, err := st.readIPs(st.IpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := ips.Total(); total != 4+1 {
+		t.Errorf("Total() = %d, want 5", total)
+	}
+	for {
+		ip, ok := ips.Next()
+		if !ok {
+			break
+		}
+		if ip.Port != 8443 {
+			t.Errorf("Next() = %s, want the default port", ip.String())
+		}
+	}
+}
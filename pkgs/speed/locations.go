@@ -0,0 +1,124 @@
+package speed
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 内置的位置信息，来自https://speed.cloudflare.com/locations，没有缓存时使用
+//
+//go:embed locations.json
+var embeddedLocations []byte
+
+// 缓存超过这个时间后提示更新
+const locationCacheMaxAge = 30 * 24 * time.Hour
+
+// LocationCache 缓存到本地的位置信息
+type LocationCache struct {
+	UpdatedAt time.Time  `json:"updated_at"` // 更新时间
+	Source    string     `json:"source"`     // 获取位置信息的地址
+	Locations []Location `json:"locations"`
+}
+
+// LocationCachePath 返回位置信息缓存文件的路径，设置了CFIPTEST_CACHE_DIR环境变量时使用该目录
+func LocationCachePath() (string, error) {
+	dir, ok := os.LookupEnv("CFIPTEST_CACHE_DIR")
+	if !ok || dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(cacheDir, "cfiptest")
+	}
+	return filepath.Join(dir, "locations.json"), nil
+}
+
+// ReadLocationCache 读取本地缓存的位置信息
+func ReadLocationCache() (*LocationCache, error) {
+	path, err := LocationCachePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cache LocationCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("位置信息缓存 %s 格式不正确: %w", path, err)
+	}
+	if len(cache.Locations) == 0 {
+		return nil, fmt.Errorf("位置信息缓存 %s 为空", path)
+	}
+	return &cache, nil
+}
+
+// UpdateLocations 从url获取位置信息并写入本地缓存，返回写入的缓存
+func UpdateLocations(ctx context.Context, url string) (*LocationCache, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", UA)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("请求 %s 失败，状态码 %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &LocationCache{UpdatedAt: time.Now(), Source: url}
+	if err := json.Unmarshal(body, &cache.Locations); err != nil {
+		return nil, fmt.Errorf("%s 返回的位置信息格式不正确: %w", url, err)
+	}
+	if len(cache.Locations) == 0 {
+		return nil, fmt.Errorf("%s 没有返回位置信息", url)
+	}
+
+	path, err := LocationCachePath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// loadLocations 优先使用本地缓存的位置信息，没有缓存或缓存不可用时使用内置的位置信息
+func (st *CFSpeedTest) loadLocations() []Location {
+	cache, err := ReadLocationCache()
+	if err == nil {
+		if time.Since(cache.UpdatedAt) > locationCacheMaxAge {
+			st.printf("位置信息缓存更新于 %s，可以使用 cfiptest locations update 更新\n", cache.UpdatedAt.Format("2006-01-02"))
+		}
+		return cache.Locations
+	}
+	if !os.IsNotExist(err) {
+		st.printf("读取位置信息缓存失败，使用内置的位置信息: %v\n", err)
+	}
+
+	var locations []Location
+	json.Unmarshal(embeddedLocations, &locations)
+	return locations
+}
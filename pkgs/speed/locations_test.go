@@ -0,0 +1,72 @@
+package speed
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateLocations(t *testing.T) {
+	t.Setenv("CFIPTEST_CACHE_DIR", t.TempDir())
+	st := &CFSpeedTest{Stdout: io.Discard}
+
+	// 没有缓存时使用内置的位置信息
+	if loc, ok := st.GetLocationMap()["HKG"]; !ok || loc.City != "Hong Kong" {
+		t.Fatalf("embedded HKG = %+v", loc)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/locations" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `[{"iata":"HKG","lat":22.3,"lon":113.9,"cca2":"HK","region":"Asia Pacific","city":"Hong Kong"},{"iata":"NEW","lat":1,"lon":2,"cca2":"XX","region":"Asia Pacific","city":"New City"}]`)
+	}))
+	defer srv.Close()
+
+	cache, err := UpdateLocations(context.Background(), srv.URL+"/locations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.Locations) != 2 || cache.Source != srv.URL+"/locations" || cache.UpdatedAt.IsZero() {
+		t.Errorf("cache = %+v", cache)
+	}
+	// 通过临时文件写入缓存，写入后不留下临时文件
+	path, _ := LocationCachePath()
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(path) {
+		t.Errorf("cache dir entries = %v", entries)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0644 {
+		t.Errorf("cache file mode = %v, want 0644", info.Mode())
+	}
+	locationMap := st.GetLocationMap()
+	if len(locationMap) != 2 || locationMap["NEW"].City != "New City" {
+		t.Errorf("cached locations = %v", locationMap)
+	}
+
+	// 获取失败时不覆盖缓存
+	if _, err := UpdateLocations(context.Background(), srv.URL+"/missing"); err == nil {
+		t.Error("UpdateLocations() with 404 should fail")
+	}
+	if _, ok := st.GetLocationMap()["NEW"]; !ok {
+		t.Error("cache overwritten by failed update")
+	}
+
+	// 缓存损坏时使用内置的位置信息
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := st.GetLocationMap()["SIN"]; !ok {
+		t.Error("corrupt cache should fall back to embedded locations")
+	}
+}
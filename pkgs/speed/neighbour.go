@@ -0,0 +1,163 @@
+package speed
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/netip"
+)
+
+// 未设置邻近搜索子网前缀时的默认值，与命令行参数的默认值相同
+const (
+	defaultNeighbourPrefix  = 24
+	defaultNeighbourPrefix6 = 120
+)
+
+// sliceIterator 依次返回切片中的IP
+type sliceIterator struct {
+	ips []IpPair
+	idx int
+}
+
+func (it *sliceIterator) Next() (IpPair, bool) {
+	if it.idx >= len(it.ips) {
+		return IpPair{}, false
+	}
+	ip := it.ips[it.idx]
+	it.idx++
+	return ip, true
+}
+
+func (it *sliceIterator) Total() int64 {
+	return int64(len(it.ips))
+}
+
+// NewSliceIterator 依次返回ips中的IP
+func NewSliceIterator(ips []IpPair) IpIterator {
+	return &sliceIterator{ips: ips}
+}
+
+// neighbourSubnet 邻近搜索中的一个子网
+type neighbourSubnet struct {
+	prefix netip.Prefix
+	parts  []netip.Prefix // 子网去除排除的IP段后剩余的IP段
+	port   int
+	rnd    *rand.Rand
+	tested map[netip.Addr]struct{} // 子网内已测试过的IP
+}
+
+// pick 随机挑选最多count个未测试过的IP
+func (s *neighbourSubnet) pick(count int) []IpPair {
+	size := partsSize(s.parts)
+	if size >= 0 && int64(len(s.tested)+count) > size {
+		count = int(size) - len(s.tested)
+	}
+
+	var ips []IpPair
+	for len(ips) < count {
+		addr := randomAddr(s.rnd, s.parts)
+		if _, ok := s.tested[addr]; ok {
+			continue
+		}
+		s.tested[addr] = struct{}{}
+		ips = append(ips, IpPair{IP: addr.String(), Port: s.port})
+	}
+	return ips
+}
+
+// subnetKey 返回IP所在的邻近搜索子网，无法解析的IP返回false
+func (st *CFSpeedTest) subnetKey(ip string, port int) (string, netip.Prefix, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", netip.Prefix{}, false
+	}
+	bits := st.NeighbourPrefix
+	if !addr.Is4() {
+		bits = st.NeighbourPrefix6
+	}
+	prefix, err := addr.Prefix(min(bits, addr.BitLen()))
+	if err != nil {
+		return "", netip.Prefix{}, false
+	}
+	return fmt.Sprintf("%s,%d", prefix, port), prefix, true
+}
+
+// SearchNeighbours 在优选IP所在的子网中继续搜索，保留产生优选结果的子网进入下一轮，淘汰其余子网，
+// 返回的结果个数不超过原来的优选个数
+func (st *CFSpeedTest) SearchNeighbours(ctx context.Context, results []*SpeedTestResult) []*SpeedTestResult {
+	if ctx.Err() != nil || st.NeighbourRounds <= 0 || st.NeighbourCount <= 0 || len(results) == 0 {
+		return results
+	}
+
+	// 最终保留的优选个数，新结果进入前limit名的子网视为有效
+	limit := len(results)
+	if st.SpeedTestThread > 0 && st.MaxSpeedTestCount > 0 {
+		limit = st.MaxSpeedTestCount
+	}
+
+	rnd := rand.New(rand.NewSource(st.Seed))
+	active := make(map[string]*neighbourSubnet)
+	for _, res := range results {
+		key, prefix, ok := st.subnetKey(res.IP, res.Port)
+		if !ok {
+			continue
+		}
+		subnet, ok := active[key]
+		if !ok {
+			parts := []netip.Prefix{prefix}
+			if st.excludeSet != nil {
+				parts = st.excludeSet.Subtract(prefix)
+			}
+			// 整个子网都被排除时不搜索
+			if len(parts) == 0 {
+				continue
+			}
+			subnet = &neighbourSubnet{prefix: prefix, parts: parts, port: res.Port, rnd: rnd, tested: make(map[netip.Addr]struct{})}
+			active[key] = subnet
+		}
+		subnet.tested[netip.MustParseAddr(res.IP)] = struct{}{}
+	}
+
+	for round := 1; round <= st.NeighbourRounds && len(active) > 0 && ctx.Err() == nil; round++ {
+		var ips []IpPair
+		for key, subnet := range active {
+			picked := subnet.pick(st.NeighbourCount)
+			if len(picked) == 0 {
+				delete(active, key)
+			}
+			ips = append(ips, picked...)
+		}
+		if len(ips) == 0 {
+			break
+		}
+		st.printf("开始第%d轮邻近搜索，子网个数：%d，待测试IP：%d\n", round, len(active), len(ips))
+
+		resultChan := st.TestDelay(ctx, &sliceIterator{ips: ips})
+		found := st.TestDownload(ctx, resultChan)
+		results = append(results, found...)
+		st.sortResults(results)
+		results = st.limitPerColo(results)
+
+		// 只有新结果进入优选名单的子网才继续搜索
+		next := make(map[string]*neighbourSubnet)
+		for _, res := range results[:min(limit, len(results))] {
+			key, _, ok := st.subnetKey(res.IP, res.Port)
+			if !ok || active[key] == nil || !containsResult(found, res) {
+				continue
+			}
+			next[key] = active[key]
+		}
+		st.printf("第%d轮邻近搜索完成，发现有效IP：%d，继续搜索的子网个数：%d\n", round, len(found), len(next))
+		active = next
+	}
+	return results[:min(limit, len(results))]
+}
+
+func containsResult(results []*SpeedTestResult, target *SpeedTestResult) bool {
+	for _, res := range results {
+		if res == target {
+			return true
+		}
+	}
+	return false
+}
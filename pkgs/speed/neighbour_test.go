@@ -0,0 +1,127 @@
+package speed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackrun123/cfiptest/pkgs/edgetest"
+)
+
+// logBuffer 可以并发写入的输出
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// neighbourEdges 在127.0.2.0/30的全部4个IP的同一个端口上启动模拟边缘节点，
+// 另外在127.0.3.1上启动一个延迟较高、子网内没有其他节点的边缘节点，返回两个子网的初始结果
+func neighbourEdges(t *testing.T) []*SpeedTestResult {
+	t.Helper()
+	first, err := edgetest.NewServer(edgetest.Config{Colo: "HKG", Addr: "127.0.2.1:0"})
+	if err != nil {
+		t.Skipf("无法监听127.0.2.1: %v", err)
+	}
+	t.Cleanup(first.Close)
+	for _, ip := range []string{"127.0.2.0", "127.0.2.2", "127.0.2.3"} {
+		newEdge(t, edgetest.Config{Colo: "HKG", Addr: fmt.Sprintf("%s:%d", ip, first.Port)})
+	}
+	lonely := newEdge(t, edgetest.Config{Colo: "NRT", Addr: "127.0.3.1:0", Latency: 100 * time.Millisecond})
+
+	// 初始结果的延迟与节点的延迟一致，新发现的IP总是排在127.0.3.1前面
+	return []*SpeedTestResult{
+		{Result: Result{IP: first.IP, Port: first.Port, TCPDuration: time.Millisecond}},
+		{Result: Result{IP: lonely.IP, Port: lonely.Port, TCPDuration: 100 * time.Millisecond}},
+	}
+}
+
+func TestSearchNeighbours(t *testing.T) {
+	results := neighbourEdges(t)
+	log := &logBuffer{}
+	st := newEdgeTest()
+	st.Stdout = log
+	st.SortBy = "delay"
+	st.SpeedTestThread = 1
+	st.MaxSpeedTestCount = 10
+	st.NeighbourRounds = 5
+	st.NeighbourCount = 1
+	st.NeighbourPrefix = 30
+
+	results = st.SearchNeighbours(context.Background(), results)
+
+	// 每轮在127.0.2.0/30中找到一个新的IP，127.0.3.0/30第一轮没有找到有效IP后被淘汰，
+	// 第4轮127.0.2.0/30已全部测试过，搜索结束
+	for _, want := range []string{
+		"开始第1轮邻近搜索，子网个数：2，待测试IP：2",
+		"第1轮邻近搜索完成，发现有效IP：1，继续搜索的子网个数：1",
+		"开始第2轮邻近搜索，子网个数：1，待测试IP：1",
+		"开始第3轮邻近搜索，子网个数：1，待测试IP：1",
+	} {
+		if !strings.Contains(log.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, log.String())
+		}
+	}
+	if strings.Contains(log.String(), "开始第4轮") {
+		t.Errorf("searched after the subnet was exhausted:\n%s", log.String())
+	}
+
+	ips := map[string]bool{}
+	for _, res := range results {
+		ips[res.IP] = true
+	}
+	for _, ip := range []string{"127.0.2.0", "127.0.2.1", "127.0.2.2", "127.0.2.3", "127.0.3.1"} {
+		if !ips[ip] {
+			t.Errorf("results %v missing %s", ips, ip)
+		}
+	}
+	if len(results) != 5 {
+		t.Errorf("got %d results, want 5", len(results))
+	}
+}
+
+func TestSearchNeighboursLimit(t *testing.T) {
+	results := neighbourEdges(t)
+	st := newEdgeTest()
+	st.SortBy = "delay"
+	st.SpeedTestThread = 1
+	st.MaxSpeedTestCount = 2
+	st.NeighbourRounds = 5
+	st.NeighbourCount = 1
+	st.NeighbourPrefix = 30
+
+	// 结果个数不超过MaxSpeedTestCount，延迟较高的IP被新发现的IP挤出
+	results = st.SearchNeighbours(context.Background(), results)
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	for _, res := range results {
+		if !strings.HasPrefix(res.IP, "127.0.2.") {
+			t.Errorf("result %s should have been truncated", res.IP)
+		}
+	}
+}
+
+func TestPrepareNeighbourPrefix(t *testing.T) {
+	st := &CFSpeedTest{Stdout: io.Discard, NeighbourPrefix6: 200}
+	st.prepare()
+	if st.NeighbourPrefix != 24 || st.NeighbourPrefix6 != 120 {
+		t.Errorf("prefix = %d, prefix6 = %d, want 24 and 120", st.NeighbourPrefix, st.NeighbourPrefix6)
+	}
+}
@@ -0,0 +1,221 @@
+package speed
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// OutputMeta 测试的元数据，JSON和NDJSON输出使用
+type OutputMeta struct {
+	Version          string    `json:"version"`           // 程序版本
+	StartTime        time.Time `json:"start_time"`        // 开始测试的时间
+	DelayTestURL     string    `json:"delay_url"`         // 延迟测试地址
+	DelayTestType    int       `json:"delay_test_type"`   // 延迟测试类型
+	DelayTestCount   int       `json:"delay_test_count"`  // 每个IP延迟测试次数
+	SpeedTestURL     string    `json:"speed_url"`         // 下载测速地址，未测速时为空
+	DownloadProtocol string    `json:"download_protocol"` // 下载测速协议
+	UploadTestURL    string    `json:"upload_url"`        // 上传测速地址，未测速时为空
+	TLS              bool      `json:"tls"`               // 是否启用TLS
+	Near             *GeoPoint `json:"near"`              // 距离过滤的位置，未指定时为null
+}
+
+// OutputResult 单个IP的测试结果，延迟单位为毫秒，速度单位为MB/s
+type OutputResult struct {
+	IP             string    `json:"ip"`
+	Port           int       `json:"port"`
+	TLS            bool      `json:"tls"`
+	DataCenter     string    `json:"colo"`
+	Region         string    `json:"region"`
+	City           string    `json:"city"`
+	Country        string    `json:"country"`
+	Distance       *float64  `json:"distance_km"` // 与-near指定位置的距离，未指定时为null
+	Latency        float64   `json:"latency_ms"`  // 平均延迟
+	MinLatency     float64   `json:"min_latency_ms"`
+	MedianLatency  float64   `json:"median_latency_ms"`
+	P95Latency     float64   `json:"p95_latency_ms"`
+	Jitter         float64   `json:"jitter_ms"`
+	Loss           float64   `json:"loss_percent"`
+	DownloadSpeed  float64   `json:"download_speed"`
+	PeakSpeed      float64   `json:"peak_speed"`
+	SustainedSpeed float64   `json:"sustained_speed"`
+	Stability      float64   `json:"stability"`
+	SpeedSamples   []float64 `json:"speed_samples"`      // 每个采样周期的下载速度
+	SampleInterval float64   `json:"sample_interval_ms"` // 下载速度采样间隔
+	ParallelSpeed  float64   `json:"parallel_speed"`
+	UploadSpeed    float64   `json:"upload_speed"`
+	Protocol       string    `json:"protocol"`
+	Score          float64   `json:"score"`
+}
+
+// OutputFile JSON格式的完整输出
+type OutputFile struct {
+	Meta    OutputMeta     `json:"meta"`
+	EndTime time.Time      `json:"end_time"`
+	Results []OutputResult `json:"results"`
+}
+
+// ndjsonLine NDJSON格式的一行，type为meta、result、rank或end
+type ndjsonLine struct {
+	Type    string        `json:"type"`
+	Stage   string        `json:"stage,omitempty"` // result行的测试阶段，delay或speed
+	Rank    int           `json:"rank,omitempty"`  // rank行的最终排名，从1开始
+	Meta    *OutputMeta   `json:"meta,omitempty"`
+	Result  *OutputResult `json:"result,omitempty"`
+	EndTime *time.Time    `json:"end_time,omitempty"`
+	Count   *int          `json:"count,omitempty"`
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (st *CFSpeedTest) outputMeta() OutputMeta {
+	meta := OutputMeta{
+		Version:        st.Version,
+		StartTime:      st.startTime,
+		DelayTestURL:   st.DelayTestURL,
+		DelayTestType:  st.DelayTestType,
+		DelayTestCount: max(st.DelayTestCount, 1),
+		TLS:            st.EnableTLS,
+		Near:           st.NearPoint,
+	}
+	if st.SpeedTestThread > 0 {
+		meta.SpeedTestURL = st.SpeedTestURL
+		meta.DownloadProtocol = st.downloadProtocolName()
+		meta.UploadTestURL = st.UploadTestURL
+	}
+	return meta
+}
+
+func (st *CFSpeedTest) outputResult(res *SpeedTestResult) OutputResult {
+	out := OutputResult{
+		IP:            res.IP,
+		Port:          res.Port,
+		TLS:           st.EnableTLS,
+		DataCenter:    res.DataCenter,
+		Region:        res.Region,
+		City:          res.City,
+		Country:       res.Country,
+		Latency:       toMs(res.TCPDuration),
+		MinLatency:    toMs(res.MinDelay),
+		MedianLatency: toMs(res.MedianDelay),
+		P95Latency:    toMs(res.P95Delay),
+		Jitter:        toMs(res.Jitter),
+		Loss:          res.Loss,
+		DownloadSpeed: res.DownloadSpeed,
+		ParallelSpeed: res.ParallelSpeed,
+		UploadSpeed:   res.UploadSpeed,
+		Protocol:      res.Protocol,
+		Score:         res.Score,
+		SpeedSamples:  []float64{},
+	}
+	if st.NearPoint != nil {
+		distance := res.Distance
+		out.Distance = &distance
+	}
+	if res.Throughput != nil {
+		out.PeakSpeed = res.Throughput.Peak
+		out.SustainedSpeed = res.Throughput.Sustained
+		out.Stability = res.Throughput.Stability
+		out.SampleInterval = toMs(res.Throughput.Interval)
+		if res.Throughput.Samples != nil {
+			out.SpeedSamples = res.Throughput.Samples
+		}
+	}
+	return out
+}
+
+// outputJSON 将全部结果写入一个JSON文件
+func (st *CFSpeedTest) outputJSON(results []*SpeedTestResult) {
+	file, err := os.Create(st.OutFile)
+	if err != nil {
+		st.printf("无法创建文件: %v\n", err)
+		return
+	}
+	defer file.Close()
+
+	out := OutputFile{Meta: st.outputMeta(), EndTime: time.Now(), Results: []OutputResult{}}
+	for _, res := range results {
+		out.Results = append(out.Results, st.outputResult(res))
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(out); err != nil {
+		st.printf("写入文件失败: %v\n", err)
+	}
+}
+
+// ndjsonWriter 每个IP测试完成后立即写入一行
+type ndjsonWriter struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+// openNDJSON 创建NDJSON输出文件并写入元数据
+func (st *CFSpeedTest) openNDJSON() error {
+	file, err := os.Create(st.OutFile)
+	if err != nil {
+		return err
+	}
+	st.ndjson = &ndjsonWriter{w: file}
+	meta := st.outputMeta()
+	return st.ndjson.write(ndjsonLine{Type: "meta", Meta: &meta})
+}
+
+func (w *ndjsonWriter) write(line ndjsonLine) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(append(data, '\n'))
+	return err
+}
+
+// emitResult 单个IP在stage阶段测试完成时调用，输出格式为NDJSON时立即写入。
+// 此时还没有计算评分，也没有按数据中心限制个数，最终结果在结束时的rank行中
+func (st *CFSpeedTest) emitResult(res *SpeedTestResult, stage string) {
+	if st.onResult != nil {
+		st.onResult(res)
+	}
+	if st.ndjson == nil {
+		return
+	}
+	out := st.outputResult(res)
+	st.ndjson.mu.Lock()
+	defer st.ndjson.mu.Unlock()
+	if err := st.ndjson.write(ndjsonLine{Type: "result", Stage: stage, Result: &out}); err != nil && st.VerboseMode {
+		st.printf("写入文件失败: %v\n", err)
+	}
+}
+
+// outputNDJSON 按最终排名写入评分后的结果和结束行，然后关闭文件。
+// 取消测试时只完成延迟测试的结果没有写入过result行，也会出现在rank行中
+func (st *CFSpeedTest) outputNDJSON(results []*SpeedTestResult) {
+	if st.ndjson == nil {
+		if err := st.openNDJSON(); err != nil {
+			st.printf("无法创建文件: %v\n", err)
+			return
+		}
+	}
+	w := st.ndjson
+	st.ndjson = nil
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	defer w.w.Close()
+
+	for i, res := range results {
+		out := st.outputResult(res)
+		if err := w.write(ndjsonLine{Type: "rank", Rank: i + 1, Result: &out}); err != nil {
+			st.printf("写入文件失败: %v\n", err)
+			return
+		}
+	}
+	endTime := time.Now()
+	count := len(results)
+	if err := w.write(ndjsonLine{Type: "end", EndTime: &endTime, Count: &count}); err != nil {
+		st.printf("写入文件失败: %v\n", err)
+	}
+}
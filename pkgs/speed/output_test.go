@@ -0,0 +1,173 @@
+package speed
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jackrun123/cfiptest/pkgs/edgetest"
+)
+
+// runEdgeTest 对模拟的边缘节点运行完整的测试流程，返回输出文件的路径
+func runEdgeTest(t *testing.T, format string, ips ...IpPair) string {
+	t.Helper()
+	dir := t.TempDir()
+	var lines []string
+	for _, ip := range ips {
+		lines = append(lines, fmt.Sprintf("%s,%d", ip.IP, ip.Port))
+	}
+	ipFile := filepath.Join(dir, "ip.txt")
+	if err := os.WriteFile(ipFile, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	st := newEdgeTest()
+	st.IpFile = ipFile
+	st.OutFile = filepath.Join(dir, "out."+format)
+	st.OutputFormat = format
+	st.SpeedTestThread = 1
+	st.MaxSpeedTestCount = 10
+	st.Version = "test"
+	st.Run(context.Background())
+	return st.OutFile
+}
+
+func TestRunOutputCSV(t *testing.T) {
+	edge := newEdge(t, edgetest.Config{Colo: "HKG"})
+	out := runEdgeTest(t, "csv", IpPair{IP: edge.IP, Port: edge.Port}, IpPair{IP: "127.0.0.1", Port: closedPort(t)})
+
+	file, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	if bom, _ := reader.Peek(3); string(bom) != "\xEF\xBB\xBF" {
+		t.Errorf("missing UTF-8 BOM")
+	}
+	reader.Discard(3)
+	records, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d rows, want header and 1 result", len(records))
+	}
+	if records[0][0] != "IP地址" || len(records[0]) != len(records[1]) {
+		t.Errorf("header = %v, row = %v", records[0], records[1])
+	}
+	if records[1][0] != edge.IP || records[1][3] != "HKG" || records[1][5] != "Hong Kong" {
+		t.Errorf("row = %v", records[1])
+	}
+}
+
+func TestRunOutputJSON(t *testing.T) {
+	edge := newEdge(t, edgetest.Config{Colo: "NRT"})
+	out := runEdgeTest(t, "json", IpPair{IP: edge.IP, Port: edge.Port})
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file OutputFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if file.Meta.Version != "test" || file.Meta.DelayTestURL != "edge.test" || !file.Meta.TLS {
+		t.Errorf("meta = %+v", file.Meta)
+	}
+	if len(file.Results) != 1 {
+		t.Fatalf("got %d results, want 1", len(file.Results))
+	}
+	res := file.Results[0]
+	if res.Port != edge.Port || res.DataCenter != "NRT" || res.City != "Tokyo" || res.DownloadSpeed <= 0 || res.Protocol != "HTTP/1.1" {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+func TestRunOutputNDJSON(t *testing.T) {
+	edge := newEdge(t, edgetest.Config{Colo: "SIN"})
+	out := runEdgeTest(t, "ndjson", IpPair{IP: edge.IP, Port: edge.Port})
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var l ndjsonLine
+		if err := json.Unmarshal([]byte(line), &l); err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+		types = append(types, l.Type)
+		if l.Type == "result" && (l.Stage != "speed" || l.Result.DataCenter != "SIN") {
+			t.Errorf("stage = %q, result = %+v", l.Stage, l.Result)
+		}
+		if l.Type == "rank" && (l.Rank != 1 || l.Result.DataCenter != "SIN") {
+			t.Errorf("rank = %d, result = %+v", l.Rank, l.Result)
+		}
+	}
+	if strings.Join(types, ",") != "meta,result,rank,end" {
+		t.Errorf("line types = %v", types)
+	}
+}
+
+func TestOutputNDJSONCancelled(t *testing.T) {
+	st := newEdgeTest()
+	st.OutFile = filepath.Join(t.TempDir(), "out.ndjson")
+	st.OutputFormat = "ndjson"
+	st.SpeedTestThread = 1
+	if err := st.openNDJSON(); err != nil {
+		t.Fatal(err)
+	}
+	// 延迟测试完成后取消，测速阶段直接返回延迟测试的结果
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	resultChan := make(chan Result, 2)
+	resultChan <- Result{IP: "192.0.2.1", Port: 443, DataCenter: "HKG"}
+	resultChan <- Result{IP: "192.0.2.2", Port: 443, DataCenter: "NRT"}
+	close(resultChan)
+	st.outputNDJSON(st.TestDownload(ctx, resultChan))
+
+	data, err := os.ReadFile(st.OutFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	var count int
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var l ndjsonLine
+		if err := json.Unmarshal([]byte(line), &l); err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+		types = append(types, l.Type)
+		if l.Type == "end" {
+			count = *l.Count
+		}
+	}
+	if strings.Join(types, ",") != "meta,rank,rank,end" || count != 2 {
+		t.Errorf("line types = %v, count = %d", types, count)
+	}
+}
+
+func TestRunNDJSONReadIPsFailed(t *testing.T) {
+	dir := t.TempDir()
+	st := newEdgeTest()
+	st.IpFile = filepath.Join(dir, "missing.txt")
+	st.OutFile = filepath.Join(dir, "out.ndjson")
+	st.OutputFormat = "ndjson"
+	st.Run(context.Background())
+
+	if _, err := os.Stat(st.OutFile); !os.IsNotExist(err) {
+		t.Errorf("output file should not be created, stat err = %v", err)
+	}
+	if st.ndjson != nil {
+		t.Error("ndjson writer still open")
+	}
+}
@@ -0,0 +1,76 @@
+package speed
+
+import (
+	"strings"
+)
+
+// scoreMetric 综合评分的一项指标，value越大越好
+type scoreMetric struct {
+	weight float64
+	value  func(res *SpeedTestResult) float64
+}
+
+// calculateScores 按权重计算综合评分(0~100)，每项指标先在本批结果中归一化到0~1
+func (st *CFSpeedTest) calculateScores(results []*SpeedTestResult) {
+	metrics := []scoreMetric{
+		{st.WeightLatency, func(res *SpeedTestResult) float64 { return -float64(res.TCPDuration) }},
+		{st.WeightLoss, func(res *SpeedTestResult) float64 { return -res.Loss }},
+		{st.WeightJitter, func(res *SpeedTestResult) float64 { return -float64(res.Jitter) }},
+		{st.WeightColo, st.coloPreference},
+	}
+	if st.SpeedTestThread > 0 {
+		metrics = append(metrics, scoreMetric{st.WeightSpeed, func(res *SpeedTestResult) float64 { return res.Throughput.StableSpeed() }})
+	}
+
+	var totalWeight float64
+	for _, m := range metrics {
+		totalWeight += max(m.weight, 0)
+	}
+	for _, res := range results {
+		res.Score = 0
+	}
+	if totalWeight == 0 || len(results) == 0 {
+		return
+	}
+
+	for _, m := range metrics {
+		if m.weight <= 0 {
+			continue
+		}
+		lo, hi := m.value(results[0]), m.value(results[0])
+		for _, res := range results[1:] {
+			v := m.value(res)
+			lo, hi = min(lo, v), max(hi, v)
+		}
+		for _, res := range results {
+			// 所有结果这一项都相同时不影响排名
+			normalized := 1.0
+			if hi > lo {
+				normalized = (m.value(res) - lo) / (hi - lo)
+			}
+			res.Score += m.weight * normalized / totalWeight * 100
+		}
+	}
+}
+
+// coloPreference 数据中心在优选列表中越靠前得分越高，不在列表中为0
+func (st *CFSpeedTest) coloPreference(res *SpeedTestResult) float64 {
+	for i, iata := range st.PreferIATAList {
+		if iata == res.DataCenter {
+			return 1 - float64(i)/float64(len(st.PreferIATAList))
+		}
+	}
+	return 0
+}
+
+// parseIATAList 解析英文逗号分隔的IATA列表
+func parseIATAList(s string) []string {
+	var list []string
+	for _, iata := range strings.Split(s, ",") {
+		iata = strings.ToUpper(strings.TrimSpace(iata))
+		if iata != "" {
+			list = append(list, iata)
+		}
+	}
+	return list
+}
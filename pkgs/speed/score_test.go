@@ -0,0 +1,104 @@
+package speed
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func scoreResult(colo string, delay time.Duration, loss, speed float64) *SpeedTestResult {
+	return &SpeedTestResult{
+		Result:     Result{IP: colo, DataCenter: colo, TCPDuration: delay, Loss: loss},
+		Throughput: &Throughput{Sustained: speed, Stability: 1},
+	}
+}
+
+func TestCalculateScores(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		st      CFSpeedTest
+		results []*SpeedTestResult
+		want    []float64
+	}{
+		{
+			name:    "按本批结果的最小最大值归一化",
+			st:      CFSpeedTest{WeightLatency: 1},
+			results: []*SpeedTestResult{scoreResult("A", 10*ms, 0, 0), scoreResult("B", 20*ms, 0, 0), scoreResult("C", 30*ms, 0, 0)},
+			want:    []float64{100, 50, 0},
+		},
+		{
+			name:    "全部相同时不影响排名",
+			st:      CFSpeedTest{WeightLatency: 1, WeightLoss: 1},
+			results: []*SpeedTestResult{scoreResult("A", 10*ms, 0, 0), scoreResult("B", 10*ms, 50, 0)},
+			want:    []float64{100, 50},
+		},
+		{
+			name:    "按权重加权",
+			st:      CFSpeedTest{WeightLatency: 3, WeightLoss: 1},
+			results: []*SpeedTestResult{scoreResult("A", 10*ms, 50, 0), scoreResult("B", 30*ms, 0, 0)},
+			want:    []float64{75, 25},
+		},
+		{
+			name:    "负权重不计入",
+			st:      CFSpeedTest{WeightLatency: 1, WeightLoss: -1},
+			results: []*SpeedTestResult{scoreResult("A", 10*ms, 50, 0), scoreResult("B", 30*ms, 0, 0)},
+			want:    []float64{100, 0},
+		},
+		{
+			name:    "未开启测速时不计算速度",
+			st:      CFSpeedTest{WeightLatency: 1, WeightSpeed: 1},
+			results: []*SpeedTestResult{scoreResult("A", 10*ms, 0, 1), scoreResult("B", 30*ms, 0, 9)},
+			want:    []float64{100, 0},
+		},
+		{
+			name:    "开启测速时按稳定速度计算",
+			st:      CFSpeedTest{WeightLatency: 1, WeightSpeed: 1, SpeedTestThread: 1},
+			results: []*SpeedTestResult{scoreResult("A", 10*ms, 0, 1), scoreResult("B", 30*ms, 0, 9)},
+			want:    []float64{50, 50},
+		},
+		{
+			name: "优选数据中心越靠前得分越高",
+			st:   CFSpeedTest{WeightColo: 1, PreferIATAList: []string{"HKG", "NRT"}},
+			results: []*SpeedTestResult{
+				scoreResult("SIN", 10*ms, 0, 0), scoreResult("NRT", 10*ms, 0, 0), scoreResult("HKG", 10*ms, 0, 0),
+			},
+			want: []float64{0, 50, 100},
+		},
+		{
+			name:    "权重全为0",
+			st:      CFSpeedTest{},
+			results: []*SpeedTestResult{scoreResult("A", 10*ms, 0, 0), scoreResult("B", 30*ms, 0, 0)},
+			want:    []float64{0, 0},
+		},
+	}
+	for _, tt := range tests {
+		tt.st.calculateScores(tt.results)
+		for i, res := range tt.results {
+			if math.Abs(res.Score-tt.want[i]) > 1e-9 {
+				t.Errorf("%s: %s score = %.2f, want %.2f", tt.name, res.DataCenter, res.Score, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestSortResultsByScore(t *testing.T) {
+	ms := time.Millisecond
+	st := &CFSpeedTest{SortBy: "score", SpeedTestThread: 1, WeightLatency: 1, WeightSpeed: 3}
+	results := []*SpeedTestResult{
+		scoreResult("FAST", 30*ms, 0, 10),
+		scoreResult("NEAR", 10*ms, 0, 1),
+		scoreResult("MID", 20*ms, 0, 5),
+	}
+	st.sortResults(results)
+
+	want := []string{"FAST", "MID", "NEAR"}
+	for i, res := range results {
+		if res.DataCenter != want[i] {
+			t.Fatalf("order = %s,%s,%s, want %v", results[0].DataCenter, results[1].DataCenter, results[2].DataCenter, want)
+		}
+	}
+	if results[0].Score < results[1].Score || results[1].Score < results[2].Score {
+		t.Errorf("scores not descending: %.2f, %.2f, %.2f", results[0].Score, results[1].Score, results[2].Score)
+	}
+}
@@ -2,45 +2,63 @@ package speed
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/csv"
-	"encoding/json"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/jackrun123/cfiptest/pkgs/prefixset"
 )
 
 const (
 	timeout     = 1500 * time.Millisecond // 超时时间
 	maxDuration = 3000 * time.Millisecond // 最大持续时间
+	stdinFile   = "-"                     // IP文件名为-时从标准输入读取
 	UA          = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Safari/537.36"
 )
 
 type IpPair struct {
-	ip   string
-	port int
+	IP   string
+	Port int
 }
 
 func (ip *IpPair) String() string {
-	return net.JoinHostPort(ip.ip, strconv.Itoa(ip.port))
+	return net.JoinHostPort(ip.IP, strconv.Itoa(ip.Port))
 }
 
 type Result struct {
-	ip          string        // IP地址
-	port        int           // 端口
-	dataCenter  string        // 数据中心
-	region      string        // 地区
-	city        string        // 城市
-	latency     string        // 延迟
-	tcpDuration time.Duration // TCP请求延迟
+	IP          string        // IP地址
+	Port        int           // 端口
+	DataCenter  string        // 数据中心
+	Region      string        // 地区
+	City        string        // 城市
+	Country     string        // 国家代码
+	Distance    float64       // 与-near指定位置的距离(km)
+	Latency     string        // 延迟
+	TCPDuration time.Duration // TCP请求延迟，多次测试时为平均延迟
+	MinDelay    time.Duration // 最小延迟
+	MedianDelay time.Duration // 延迟中位数
+	P95Delay    time.Duration // 95分位延迟
+	Jitter      time.Duration // 延迟抖动（标准差）
+	Loss        float64       // 丢包率(%)
 }
 
 type SpeedTestResult struct {
 	Result
-	downloadSpeed float64 // 下载速度
+	DownloadSpeed float64     // 下载速度
+	Throughput    *Throughput // 下载过程中的吞吐量统计
+	ParallelSpeed float64     // 多连接并发下载总速度
+	UploadSpeed   float64     // 上传速度
+	Protocol      string      // 测速协议
+	Score         float64     // 综合评分
 }
 
 type Location struct {
@@ -53,91 +71,227 @@ type Location struct {
 }
 
 type CFSpeedTest struct {
-	LocationMap       map[string]Location
-	SpeedTestTimeout  int
-	IpFile            string
-	OutFile           string
-	DefaultPort       int
-	MaxThread         int
-	SpeedTestThread   int
-	DelayTestURL      string
-	SpeedTestURL      string
-	TestWebSocket     bool
-	MaxSpeedTestCount int
-	MaxDelayCount     int
-	MinSpeed          float64
-	EnableTLS         bool
-	Shuffle           bool
-	VerboseMode       bool
-	FilterIATA        string
-	FilterIATASet     map[string]*struct{}
-	DelayTestType     int
+	LocationMap       map[string]Location  `yaml:"-"`
+	SpeedTestTimeout  int                  `yaml:"speed_test_timeout"`
+	IpFile            string               `yaml:"ip_file"`
+	OutFile           string               `yaml:"out_file"`
+	DefaultPort       int                  `yaml:"default_port"`
+	MaxThread         int                  `yaml:"max_thread"`
+	SpeedTestThread   int                  `yaml:"speed_test_thread"`
+	DelayTestURL      string               `yaml:"delay_test_url"`
+	SpeedTestURL      string               `yaml:"speed_test_url"`
+	TestWebSocket     bool                 `yaml:"test_websocket"`
+	MaxSpeedTestCount int                  `yaml:"max_speed_test_count"`
+	MaxDelayCount     int                  `yaml:"max_delay_count"`
+	MinSpeed          float64              `yaml:"min_speed"`
+	EnableTLS         bool                 `yaml:"enable_tls"`
+	Shuffle           bool                 `yaml:"shuffle"`
+	VerboseMode       bool                 `yaml:"verbose_mode"`
+	FilterIATA        string               `yaml:"filter_iata"`
+	FilterIATASet     map[string]*struct{} `yaml:"-"`
+	ExcludeIATA       string               `yaml:"exclude_iata"` // 排除的数据中心，多个用英文逗号分隔
+	ExcludeIATASet    map[string]*struct{} `yaml:"-"`
+	ColoQuota         int                  `yaml:"colo_quota"`     // 每个数据中心最多保留的IP个数，0表示不限制
+	FilterCountry     string               `yaml:"filter_country"` // 使用国家代码过滤，多个用英文逗号分隔
+	FilterCountrySet  map[string]*struct{} `yaml:"-"`
+	FilterRegion      string               `yaml:"filter_region"` // 使用地区过滤，多个用英文逗号分隔
+	FilterRegionSet   map[string]*struct{} `yaml:"-"`
+	Near              string               `yaml:"near"` // 距离过滤，格式为"纬度,经度:半径km"或"IATA:半径km"
+	NearPoint         *GeoPoint            `yaml:"-"`    // Near解析后的位置
+	DelayTestType     int                  `yaml:"delay_test_type"`
+	QuicPort          int                  `yaml:"quic_port"`         // HTTP/3测试使用的UDP端口，0表示与IP的端口相同
+	DownloadProtocol  string               `yaml:"download_protocol"` // 测速协议，h1或h3
+	ParallelConns     int                  `yaml:"parallel_conns"`    // 并发测速的连接数，大于1时额外测试多连接总速度
+	UploadTestURL     string               `yaml:"upload_test_url"`   // 上传测速地址，为空时不测试上传
+	UploadSize        int                  `yaml:"upload_size"`       // 上传测速的数据大小(MB)
+	SampleInterval    int                  `yaml:"sample_interval"`   // 下载测速的吞吐量采样间隔(毫秒)
+	WeightLatency     float64              `yaml:"weight_latency"`    // 综合评分中延迟的权重
+	WeightSpeed       float64              `yaml:"weight_speed"`      // 综合评分中速度的权重
+	WeightLoss        float64              `yaml:"weight_loss"`       // 综合评分中丢包率的权重
+	WeightJitter      float64              `yaml:"weight_jitter"`     // 综合评分中抖动的权重
+	WeightColo        float64              `yaml:"weight_colo"`       // 综合评分中优选数据中心的权重
+	PreferIATA        string               `yaml:"prefer_iata"`       // 优选数据中心，多个用英文逗号分隔，越靠前得分越高
+	PreferIATAList    []string             `yaml:"-"`                 // PreferIATA解析后的列表
+	DelayTestCount    int                  `yaml:"delay_test_count"`  // 每个IP延迟测试次数
+	SortBy            string               `yaml:"sort_by"`           // 结果排序字段，为空时开启测速按稳定速度排序，否则按延迟排序
+	SampleCount       int                  `yaml:"sample_count"`      // CIDR中每个子网随机抽取的IP个数，0表示全部测试
+	SamplePrefix      int                  `yaml:"sample_prefix"`     // IPv4抽样子网的前缀长度
+	SamplePrefix6     int                  `yaml:"sample_prefix6"`    // IPv6抽样子网的前缀长度
+	ExcludeFile       string               `yaml:"exclude_file"`      // 不测试的IP段文件，每行一个IP或CIDR
+	NeighbourRounds   int                  `yaml:"neighbour_rounds"`  // 邻近搜索轮数，0表示不搜索
+	NeighbourCount    int                  `yaml:"neighbour_count"`   // 邻近搜索每轮每个子网测试的IP个数
+	NeighbourPrefix   int                  `yaml:"neighbour_prefix"`  // IPv4邻近搜索子网的前缀长度，0表示/24
+	NeighbourPrefix6  int                  `yaml:"neighbour_prefix6"` // IPv6邻近搜索子网的前缀长度，0表示/120
+	OutputFormat      string               `yaml:"output_format"`     // 输出格式，csv、json或ndjson
+	Version           string               `yaml:"-"`                 // 程序版本，写入JSON输出的元数据
+	Stdout            io.Writer            `yaml:"-"`                 // 进度和日志的输出位置，为nil时输出到标准输出
+	Stdin             io.Reader            `yaml:"-"`                 // IpFile为-时读取IP的位置，为nil时使用标准输入
+	Prefixes          []netip.Prefix       `yaml:"-"`                 // 待测试的IP段，不为空时代替IpFile，例如asn获取的IP段
+	Seed              int64                `yaml:"seed"`              // 抽样和打乱顺序使用的随机种子，0表示随机生成
+	StateFile         string               `yaml:"state_file"`        // 进度文件，为空时不保存进度
+	Resume            bool                 `yaml:"resume"`            // 是否从进度文件继续测试
+
+	onResult func(*SpeedTestResult) // 单个IP测试完成时的回调
+
+	startTime  time.Time      // 开始测试的时间
+	ndjson     *ndjsonWriter  // 输出格式为NDJSON时边测试边写入
+	checkpoint *checkpoint    // 保存进度时记录已完成的IP和结果
+	excludeSet *prefixset.Set // ExcludeFile中不测试的IP段
+	stdinData  []byte         // 从标准输入读取的IP列表，标准输入只能读取一次
+}
+
+func (st *CFSpeedTest) printf(format string, a ...any) {
+	if st.Stdout == nil {
+		fmt.Printf(format, a...)
+		return
+	}
+	fmt.Fprintf(st.Stdout, format, a...)
+}
+
+func (st *CFSpeedTest) println(a ...any) {
+	st.printf("%s", fmt.Sprintln(a...))
+}
+
+func (st *CFSpeedTest) print(a ...any) {
+	st.printf("%s", fmt.Sprint(a...))
 }
 
 func (st *CFSpeedTest) SetFromEnv() {
 	val, ok := os.LookupEnv("CFIPTEST_DELAY_TEST_URL")
 	if ok && val != "" {
 		st.DelayTestURL = val
-		fmt.Println("延迟测试地址从环境变量获取：", st.DelayTestURL)
+		st.println("延迟测试地址从环境变量获取：", st.DelayTestURL)
 	}
 
 	val, ok = os.LookupEnv("CFIPTEST_SPEED_TEST_URL")
 	if ok && val != "" {
 		st.SpeedTestURL = val
-		fmt.Println("速度测试地址从环境变量获取：", st.SpeedTestURL)
+		st.println("速度测试地址从环境变量获取：", st.SpeedTestURL)
 	}
 }
 
-func (st *CFSpeedTest) PreSetArgs() {
+func (st *CFSpeedTest) PreSetArgs() error {
 	st.SetFromEnv()
+	return st.prepare()
+}
+
+// prepare 加载位置信息并检查参数。过滤条件不正确时返回错误，避免不按要求过滤；其他参数不正确时使用默认值
+func (st *CFSpeedTest) prepare() error {
 	st.LocationMap = st.GetLocationMap()
 
-	iatas := strings.Split(st.FilterIATA, ",")
-	if len(iatas) > 0 && iatas[0] != "" {
-		st.FilterIATASet = make(map[string]*struct{})
-		for _, iata := range iatas {
-			st.FilterIATASet[iata] = &struct{}{}
-		}
+	st.FilterIATASet = parseFilterSet(st.FilterIATA)
+	st.ExcludeIATASet = parseFilterSet(st.ExcludeIATA)
+	st.FilterCountrySet = parseFilterSet(st.FilterCountry)
+	st.FilterRegionSet = parseFilterSet(st.FilterRegion)
+
+	nearPoint, err := parseNear(st.Near, st.LocationMap)
+	if err != nil {
+		return fmt.Errorf("距离过滤 %s 不正确: %w", st.Near, err)
 	}
+	st.NearPoint = nearPoint
+
+	st.PreferIATAList = parseIATAList(st.PreferIATA)
 
+	if st.Seed == 0 {
+		st.Seed = time.Now().UnixNano()
+	}
+
+	if st.DownloadProtocol != "" && st.DownloadProtocol != "h1" && st.DownloadProtocol != "h3" {
+		st.printf("不支持的测速协议 %s，使用h1测速\n", st.DownloadProtocol)
+		st.DownloadProtocol = "h1"
+	}
+
+	if st.OutputFormat != "" && st.OutputFormat != "csv" && st.OutputFormat != "json" && st.OutputFormat != "ndjson" {
+		st.printf("不支持的输出格式 %s，使用csv格式\n", st.OutputFormat)
+		st.OutputFormat = "csv"
+	}
+
+	if _, ok := sortKeys[st.SortBy]; st.SortBy != "" && !ok {
+		st.printf("不支持的排序字段 %s，使用默认排序\n", st.SortBy)
+		st.SortBy = ""
+	}
+
+	if st.NeighbourPrefix <= 0 || st.NeighbourPrefix > 32 {
+		if st.NeighbourPrefix != 0 {
+			st.printf("邻近搜索子网前缀 %d 不正确，使用/%d\n", st.NeighbourPrefix, defaultNeighbourPrefix)
+		}
+		st.NeighbourPrefix = defaultNeighbourPrefix
+	}
+	if st.NeighbourPrefix6 <= 0 || st.NeighbourPrefix6 > 128 {
+		if st.NeighbourPrefix6 != 0 {
+			st.printf("IPv6邻近搜索子网前缀 %d 不正确，使用/%d\n", st.NeighbourPrefix6, defaultNeighbourPrefix6)
+		}
+		st.NeighbourPrefix6 = defaultNeighbourPrefix6
+	}
+	return nil
 }
 
-func (st *CFSpeedTest) Run() {
-	st.PreSetArgs()
+// Run 运行测试并输出结果，ctx取消时中断测试并输出已完成的结果
+func (st *CFSpeedTest) Run(ctx context.Context) {
+	if err := st.PreSetArgs(); err != nil {
+		st.printf("%v\n", err)
+		return
+	}
 
 	startTime := time.Now()
+	st.startTime = startTime
 	if st.LocationMap == nil {
 		return
 	}
 
+	if err := st.openCheckpoint(); err != nil {
+		st.printf("无法使用进度文件: %v\n", err)
+		return
+	}
+
 	ips, err := st.readIPs(st.IpFile)
 	if err != nil {
-		fmt.Printf("无法从文件中读取 IP: %v\n", err)
+		st.abortCheckpoint()
+		st.printf("无法从文件中读取 IP: %v\n", err)
 		return
 	}
 
+	// 参数和IP列表都没有问题后才创建输出文件，避免留下没有结束行的NDJSON文件
+	if st.OutputFormat == "ndjson" {
+		if err := st.openNDJSON(); err != nil {
+			st.abortCheckpoint()
+			st.printf("无法创建文件: %v\n", err)
+			return
+		}
+	}
+
 	if st.Shuffle {
 		// 随机顺序
-		rand.Shuffle(len(ips), func(i, j int) { ips[i], ips[j] = ips[j], ips[i] })
+		ips = newShuffleIterator(ips, rand.New(rand.NewSource(st.Seed)))
 	}
 
-	resultChan := st.TestDelay(ips)
+	resultChan := st.TestDelay(ctx, ips)
 	if len(resultChan) == 0 {
+		st.closeCheckpoint(ctx)
 		// 清除输出内容
-		fmt.Print("\033[2J")
-		fmt.Println("没有发现有效的IP")
+		st.print("\033[2J")
+		st.println("没有发现有效的IP")
+		if st.ndjson != nil {
+			st.outputNDJSON(nil)
+		}
 		return
 	}
-	results := st.TestDownload(resultChan)
+	results := st.TestDownload(ctx, resultChan)
+	// 邻近搜索不保存进度，继续测试时重新搜索
+	cp := st.checkpoint
+	st.checkpoint = nil
+	results = st.SearchNeighbours(ctx, results)
+	st.checkpoint = cp
+	st.closeCheckpoint(ctx)
+	if ctx.Err() != nil {
+		st.printf("\n测试已取消，输出已完成的%d个结果\n", len(results))
+	}
 	st.Output(results)
-	fmt.Printf("成功将结果写入文件 %s，耗时 %d秒\n", st.OutFile, time.Since(startTime)/time.Second)
+	st.printf("成功将结果写入文件 %s，耗时 %d秒\n", st.OutFile, time.Since(startTime)/time.Second)
 }
 
 func (st *CFSpeedTest) GetLocationMap() map[string]Location {
-	var locations []Location
-	// 从https://speed.cloudflare.com/locations读取配置
-	body := `[{"iata":"TIA","lat":41.4146995544,"lon":19.7206001282,"cca2":"AL","region":"Europe","city":"Tirana"},{"iata":"ALG","lat":36.6910018921,"lon":3.2154099941,"cca2":"DZ","region":"Africa","city":"Algiers"},{"iata":"AAE","lat":36.85596,"lon":7.79207,"cca2":"DZ","region":"Africa","city":"Annaba"},{"iata":"ORN","lat":35.6911,"lon":-0.6416,"cca2":"DZ","region":"Africa","city":"Oran"},{"iata":"LAD","lat":-8.8583698273,"lon":13.2312002182,"cca2":"AO","region":"Africa","city":"Luanda"},{"iata":"EZE","lat":-34.8222,"lon":-58.5358,"cca2":"AR","region":"South America","city":"Buenos Aires"},{"iata":"COR","lat":-31.31,"lon":-64.208333,"cca2":"AR","region":"South America","city":"Córdoba"},{"iata":"NQN","lat":-38.9490013123,"lon":-68.1557006836,"cca2":"AR","region":"South America","city":"Neuquen"},{"iata":"EVN","lat":40.1473007202,"lon":44.3959007263,"cca2":"AM","region":"Middle East","city":"Yerevan"},{"iata":"ADL","lat":-34.9431729,"lon":138.5335637,"cca2":"AU","region":"Oceania","city":"Adelaide"},{"iata":"BNE","lat":-27.3841991425,"lon":153.117004394,"cca2":"AU","region":"Oceania","city":"Brisbane"},{"iata":"CBR","lat":-35.3069000244,"lon":149.1950073242,"cca2":"AU","region":"Oceania","city":"Canberra"},{"iata":"HBA","lat":-42.883209,"lon":147.331665,"cca2":"AU","region":"Oceania","city":"Hobart"},{"iata":"MEL","lat":-37.6733016968,"lon":144.843002319,"cca2":"AU","region":"Oceania","city":"Melbourne"},{"iata":"PER","lat":-31.9402999878,"lon":115.967002869,"cca2":"AU","region":"Oceania","city":"Perth"},{"iata":"SYD","lat":-33.9460983276,"lon":151.177001953,"cca2":"AU","region":"Oceania","city":"Sydney"},{"iata":"VIE","lat":48.1102981567,"lon":16.5697002411,"cca2":"AT","region":"Europe","city":"Vienna"},{"iata":"LLK","lat":38.7463989258,"lon":48.8180007935,"cca2":"AZ","region":"Middle East","city":"Astara"},{"iata":"GYD","lat":40.4674987793,"lon":50.0466995239,"cca2":"AZ","region":"Middle East","city":"Baku"},{"iata":"BAH","lat":26.2707996368,"lon":50.6335983276,"cca2":"BH","region":"Middle East","city":"Manama"},{"iata":"CGP","lat":22.2495995,"lon":91.8133011,"cca2":"BD","region":"Asia Pacific","city":"Chittagong"},{"iata":"DAC","lat":23.843347,"lon":90.397783,"cca2":"BD","region":"Asia Pacific","city":"Dhaka"},{"iata":"JSR","lat":23.1837997437,"lon":89.1607971191,"cca2":"BD","region":"Asia Pacific","city":"Jashore"},{"iata":"BGI","lat":13.103562,"lon":-59.603226,"cca2":"BB","region":"North America","city":"Bridgetown"},{"iata":"MSQ","lat":53.9006,"lon":27.599,"cca2":"BY","region":"Europe","city":"Minsk"},{"iata":"BRU","lat":50.9014015198,"lon":4.4844398499,"cca2":"BE","region":"Europe","city":"Brussels"},{"iata":"PBH","lat":27.4712,"lon":89.6339,"cca2":"BT","region":"Asia Pacific","city":"Thimphu"},{"iata":"LPB","lat":-16.4897,"lon":-68.1193,"cca2":"BO","region":"South America","city":"La Paz"},{"iata":"GBE","lat":-24.6282,"lon":25.9231,"cca2":"BW","region":"Africa","city":"Gaborone"},{"iata":"QWJ","lat":-22.738,"lon":-47.334,"cca2":"BR","region":"South America","city":"Americana"},{"iata":"BEL","lat":-1.4563,"lon":-48.5013,"cca2":"BR","region":"South America","city":"Belém"},{"iata":"CNF","lat":-19.624444,"lon":-43.971944,"cca2":"BR","region":"South America","city":"Belo Horizonte"},{"iata":"BNU","lat":-26.89245,"lon":-49.07696,"cca2":"BR","region":"South America","city":"Blumenau"},{"iata":"BSB","lat":-15.79824,"lon":-47.90859,"cca2":"BR","region":"South America","city":"Brasilia"},{"iata":"CFC","lat":-26.7762,"lon":-51.0125,"cca2":"BR","region":"South America","city":"Cacador"},{"iata":"VCP","lat":-22.90662,"lon":-47.08576,"cca2":"BR","region":"South America","city":"Campinas"},{"iata":"CAW","lat":-21.698299408,"lon":-41.301700592,"cca2":"BR","region":"South America","city":"Campos dos Goytacazes"},{"iata":"XAP","lat":-27.1341991425,"lon":-52.6566009521,"cca2":"BR","region":"South America","city":"Chapeco"},{"iata":"CGB","lat":-15.59611,"lon":-56.09667,"cca2":"BR","region":"South America","city":"Cuiaba"},{"iata":"CWB","lat":-25.5284996033,"lon":-49.1758003235,"cca2":"BR","region":"South America","city":"Curitiba"},{"iata":"FLN","lat":-27.6702785492,"lon":-48.5525016785,"cca2":"BR","region":"South America","city":"Florianopolis"},{"iata":"FOR","lat":-3.7762799263,"lon":-38.5326004028,"cca2":"BR","region":"South America","city":"Fortaleza"},{"iata":"GYN","lat":-16.69727,"lon":-49.26851,"cca2":"BR","region":"South America","city":"Goiania"},{"iata":"ITJ","lat":-27.6116676331,"lon":-48.6727790833,"cca2":"BR","region":"South America","city":"Itajai"},{"iata":"JOI","lat":-26.304408,"lon":-48.846383,"cca2":"BR","region":"South America","city":"Joinville"},{"iata":"JDO","lat":-7.2242,"lon":-39.313,"cca2":"BR","region":"South America","city":"Juazeiro do Norte"},{"iata":"MAO","lat":-3.11286,"lon":-60.01949,"cca2":"BR","region":"South America","city":"Manaus"},{"iata":"POA","lat":-29.9944000244,"lon":-51.1713981628,"cca2":"BR","region":"South America","city":"Porto Alegre"},{"iata":"REC","lat":-8.1264896393,"lon":-34.9235992432,"cca2":"BR","region":"South America","city":"Recife"},{"iata":"RAO","lat":-21.1363887787,"lon":-47.7766685486,"cca2":"BR","region":"South America","city":"Ribeirao Preto"},{"iata":"GIG","lat":-22.8099994659,"lon":-43.2505569458,"cca2":"BR","region":"South America","city":"Rio de Janeiro"},{"iata":"SSA","lat":-12.9086112976,"lon":-38.3224983215,"cca2":"BR","region":"South America","city":"Salvador"},{"iata":"SJP","lat":-20.807157,"lon":-49.378994,"cca2":"BR","region":"South America","city":"São José do Rio Preto"},{"iata":"SJK","lat":-23.1791,"lon":-45.8872,"cca2":"BR","region":"South America","city":"São José dos Campos"},{"iata":"GRU","lat":-23.4355564117,"lon":-46.4730567932,"cca2":"BR","region":"South America","city":"São Paulo"},{"iata":"SOD","lat":-23.54389,"lon":-46.63445,"cca2":"BR","region":"South America","city":"Sorocaba"},{"iata":"NVT","lat":-26.8251,"lon":-49.2695,"cca2":"BR","region":"South America","city":"Timbo"},{"iata":"UDI","lat":-18.8836116791,"lon":-48.225276947,"cca2":"BR","region":"South America","city":"Uberlandia"},{"iata":"VIX","lat":-20.64871,"lon":-41.90857,"cca2":"BR","region":"South America","city":"Vitoria"},{"iata":"BWN","lat":4.903052,"lon":114.939819,"cca2":"BN","region":"Asia Pacific","city":"Bandar Seri Begawan"},{"iata":"SOF","lat":42.6966934204,"lon":23.4114360809,"cca2":"BG","region":"Europe","city":"Sofia"},{"iata":"OUA","lat":12.3531999588,"lon":-1.5124200583,"cca2":"BF","region":"Africa","city":"Ouagadougou"},{"iata":"PNH","lat":11.5466003418,"lon":104.84400177,"cca2":"KH","region":"Asia Pacific","city":"Phnom Penh"},{"iata":"YYC","lat":51.113899231,"lon":-114.019996643,"cca2":"CA","region":"North America","city":"Calgary"},{"iata":"YVR","lat":49.193901062,"lon":-123.183998108,"cca2":"CA","region":"North America","city":"Vancouver"},{"iata":"YWG","lat":49.9099998474,"lon":-97.2398986816,"cca2":"CA","region":"North America","city":"Winnipeg"},{"iata":"YHZ","lat":44.64601,"lon":-63.66844,"cca2":"CA","region":"North America","city":"Halifax"},{"iata":"YOW","lat":45.3224983215,"lon":-75.6691970825,"cca2":"CA","region":"North America","city":"Ottawa"},{"iata":"YYZ","lat":43.6772003174,"lon":-79.6305999756,"cca2":"CA","region":"North America","city":"Toronto"},{"iata":"YUL","lat":45.4706001282,"lon":-73.7407989502,"cca2":"CA","region":"North America","city":"Montréal"},{"iata":"YXE","lat":52.1707992554,"lon":-106.699996948,"cca2":"CA","region":"North America","city":"Saskatoon"},{"iata":"ARI","lat":-18.348611,"lon":-70.338889,"cca2":"CL","region":"South America","city":"Arica"},{"iata":"SCL","lat":-33.3930015564,"lon":-70.7857971191,"cca2":"CL","region":"South America","city":"Santiago"},{"iata":"BOG","lat":4.70159,"lon":-74.1469,"cca2":"CO","region":"South America","city":"Bogotá"},{"iata":"MDE","lat":6.16454,"lon":-75.4231,"cca2":"CO","region":"South America","city":"Medellín"},{"iata":"FIH","lat":-4.3857498169,"lon":15.4446001053,"cca2":"CD","region":"Africa","city":"Kinshasa"},{"iata":"SJO","lat":9.9938602448,"lon":-84.2088012695,"cca2":"CR","region":"South America","city":"San José"},{"iata":"ZAG","lat":45.7429008484,"lon":16.0687999725,"cca2":"HR","region":"Europe","city":"Zagreb"},{"iata":"CUR","lat":12.1888999939,"lon":-68.9598007202,"cca2":"CW","region":"North America","city":"Willemstad"},{"iata":"LCA","lat":34.8750991821,"lon":33.6249008179,"cca2":"CY","region":"Europe","city":"Nicosia"},{"iata":"PRG","lat":50.1007995605,"lon":14.2600002289,"cca2":"CZ","region":"Europe","city":"Prague"},{"iata":"CPH","lat":55.6179008484,"lon":12.6560001373,"cca2":"DK","region":"Europe","city":"Copenhagen"},{"iata":"JIB","lat":11.5473003387,"lon":43.1595001221,"cca2":"DJ","region":"Africa","city":"Djibouti"},{"iata":"STI","lat":19.4060993195,"lon":-70.6046981812,"cca2":"DO","region":"North America","city":"Santiago de los Caballeros"},{"iata":"SDQ","lat":18.4297008514,"lon":-69.6688995361,"cca2":"DO","region":"North America","city":"Santo Domingo"},{"iata":"GYE","lat":-2.1894,"lon":-79.8891,"cca2":"EC","region":"South America","city":"Guayaquil"},{"iata":"UIO","lat":-0.1291666667,"lon":-78.3575,"cca2":"EC","region":"South America","city":"Quito"},{"iata":"CAI","lat":30.1219005585,"lon":31.4055995941,"cca2":"EG","region":"Africa","city":"Cairo"},{"iata":"TLL","lat":59.4132995605,"lon":24.8327999115,"cca2":"EE","region":"Europe","city":"Tallinn"},{"iata":"SUV","lat":-18.11319,"lon":178.43859,"cca2":"FJ","region":"Oceania","city":"Suva"},{"iata":"HEL","lat":60.317199707,"lon":24.963300705,"cca2":"FI","region":"Europe","city":"Helsinki"},{"iata":"BOD","lat":44.82946,"lon":-0.58355,"cca2":"FR","region":"Europe","city":"Bordeaux"},{"iata":"LYS","lat":45.7263,"lon":5.0908,"cca2":"FR","region":"Europe","city":"Lyon"},{"iata":"MRS","lat":43.439271922,"lon":5.2214241028,"cca2":"FR","region":"Europe","city":"Marseille"},{"iata":"CDG","lat":49.0127983093,"lon":2.5499999523,"cca2":"FR","region":"Europe","city":"Paris"},{"iata":"PPT","lat":-17.5536994934,"lon":-149.606994629,"cca2":"PF","region":"Oceania","city":"Tahiti"},{"iata":"TBS","lat":41.6692008972,"lon":44.95470047,"cca2":"GE","region":"Europe","city":"Tbilisi"},{"iata":"TXL","lat":52.5597000122,"lon":13.2876996994,"cca2":"DE","region":"Europe","city":"Berlin"},{"iata":"DUS","lat":51.2895011902,"lon":6.7667798996,"cca2":"DE","region":"Europe","city":"Düsseldorf"},{"iata":"FRA","lat":50.0264015198,"lon":8.543129921,"cca2":"DE","region":"Europe","city":"Frankfurt"},{"iata":"HAM","lat":53.6304016113,"lon":9.9882297516,"cca2":"DE","region":"Europe","city":"Hamburg"},{"iata":"MUC","lat":48.3538017273,"lon":11.7861003876,"cca2":"DE","region":"Europe","city":"Munich"},{"iata":"STR","lat":48.783333,"lon":9.183333,"cca2":"DE","region":"Europe","city":"Stuttgart"},{"iata":"ACC","lat":5.614818,"lon":-0.205874,"cca2":"GH","region":"Africa","city":"Accra"},{"iata":"ATH","lat":37.9364013672,"lon":23.9444999695,"cca2":"GR","region":"Europe","city":"Athens"},{"iata":"SKG","lat":40.5196990967,"lon":22.9708995819,"cca2":"GR","region":"Europe","city":"Thessaloniki"},{"iata":"GND","lat":12.007116,"lon":-61.7882288,"cca2":"GD","region":"South America","city":"St. George's"},{"iata":"GUM","lat":13.4834003448,"lon":144.796005249,"cca2":"GU","region":"Asia Pacific","city":"Hagatna"},{"iata":"GUA","lat":14.5832996368,"lon":-90.5274963379,"cca2":"GT","region":"North America","city":"Guatemala City"},{"iata":"GEO","lat":6.825648,"lon":-58.163756,"cca2":"GY","region":"South America","city":"Georgetown"},{"iata":"PAP","lat":18.5799999237,"lon":-72.2925033569,"cca2":"HT","region":"North America","city":"Port-au-Prince"},{"iata":"TGU","lat":14.0608,"lon":-87.2172,"cca2":"HN","region":"South America","city":"Tegucigalpa"},{"iata":"HKG","lat":22.3089008331,"lon":113.915000916,"cca2":"HK","region":"Asia Pacific","city":"Hong Kong"},{"iata":"BUD","lat":47.4369010925,"lon":19.2555999756,"cca2":"HU","region":"Europe","city":"Budapest"},{"iata":"KEF","lat":63.9850006104,"lon":-22.6056003571,"cca2":"IS","region":"Europe","city":"Reykjavík"},{"iata":"AMD","lat":23.0225,"lon":72.5714,"cca2":"IN","region":"Asia Pacific","city":"Ahmedabad"},{"iata":"BLR","lat":13.7835719,"lon":76.6165937,"cca2":"IN","region":"Asia Pacific","city":"Bangalore"},{"iata":"BBI","lat":20.2961,"lon":85.8245,"cca2":"IN","region":"Asia Pacific","city":"Bhubaneswar"},{"iata":"IXC","lat":30.673500061,"lon":76.7884979248,"cca2":"IN","region":"Asia Pacific","city":"Chandigarh"},{"iata":"MAA","lat":12.9900054932,"lon":80.1692962646,"cca2":"IN","region":"Asia Pacific","city":"Chennai"},{"iata":"HYD","lat":17.2313175201,"lon":78.4298553467,"cca2":"IN","region":"Asia Pacific","city":"Hyderabad"},{"iata":"CNN","lat":11.915858,"lon":75.55094,"cca2":"IN","region":"Asia Pacific","city":"Kannur"},{"iata":"KNU","lat":26.4499,"lon":80.3319,"cca2":"IN","region":"Asia Pacific","city":"Kanpur"},{"iata":"COK","lat":9.9312,"lon":76.2673,"cca2":"IN","region":"Asia Pacific","city":"Kochi"},{"iata":"CCU","lat":22.6476933,"lon":88.4349249,"cca2":"IN","region":"Asia Pacific","city":"Kolkata"},{"iata":"BOM","lat":19.0886993408,"lon":72.8678970337,"cca2":"IN","region":"Asia Pacific","city":"Mumbai"},{"iata":"NAG","lat":21.1610714,"lon":79.0024702,"cca2":"IN","region":"Asia Pacific","city":"Nagpur"},{"iata":"DEL","lat":28.5664997101,"lon":77.1031036377,"cca2":"IN","region":"Asia Pacific","city":"New Delhi"},{"iata":"PAT","lat":25.591299057,"lon":85.0879974365,"cca2":"IN","region":"Asia Pacific","city":"Patna"},{"iata":"DPS","lat":-8.748169899,"lon":115.1669998169,"cca2":"ID","region":"Asia Pacific","city":"Denpasar"},{"iata":"CGK","lat":-6.1275229,"lon":106.6515118,"cca2":"ID","region":"Asia Pacific","city":"Jakarta"},{"iata":"JOG","lat":-7.7881798744,"lon":110.4319992065,"cca2":"ID","region":"Asia Pacific","city":"Yogyakarta"},{"iata":"BGW","lat":33.2625007629,"lon":44.2346000671,"cca2":"IQ","region":"Middle East","city":"Baghdad"},{"iata":"BSR","lat":30.5491008759,"lon":47.6621017456,"cca2":"IQ","region":"Middle East","city":"Basra"},{"iata":"EBL","lat":36.1901,"lon":43.993,"cca2":"IQ","region":"Middle East","city":"Erbil"},{"iata":"NJF","lat":31.989722,"lon":44.404167,"cca2":"IQ","region":"Middle East","city":"Najaf"},{"iata":"XNH","lat":30.9358005524,"lon":46.0900993347,"cca2":"IQ","region":"Middle East","city":"Nasiriyah"},{"iata":"ISU","lat":35.5668,"lon":45.4161,"cca2":"IQ","region":"Middle East","city":"Sulaymaniyah"},{"iata":"ORK","lat":51.8413009644,"lon":-8.491109848,"cca2":"IE","region":"Europe","city":"Cork"},{"iata":"DUB","lat":53.4212989807,"lon":-6.270070076,"cca2":"IE","region":"Europe","city":"Dublin"},{"iata":"HFA","lat":32.78492,"lon":34.96069,"cca2":"IL","region":"Middle East","city":"Haifa"},{"iata":"TLV","lat":32.0113983154,"lon":34.8866996765,"cca2":"IL","region":"Middle East","city":"Tel Aviv"},{"iata":"MXP","lat":45.6305999756,"lon":8.7281103134,"cca2":"IT","region":"Europe","city":"Milan"},{"iata":"PMO","lat":38.16114,"lon":13.31546,"cca2":"IT","region":"Europe","city":"Palermo"},{"iata":"FCO","lat":41.8045005798,"lon":12.2508001328,"cca2":"IT","region":"Europe","city":"Rome"},{"iata":"KIN","lat":17.9951,"lon":-76.7846,"cca2":"JM","region":"North America","city":"Kingston"},{"iata":"FUK","lat":33.5902,"lon":130.4017,"cca2":"JP","region":"Asia Pacific","city":"Fukuoka"},{"iata":"OKA","lat":26.1958,"lon":127.646,"cca2":"JP","region":"Asia Pacific","city":"Naha"},{"iata":"KIX","lat":34.4272994995,"lon":135.244003296,"cca2":"JP","region":"Asia Pacific","city":"Osaka"},{"iata":"NRT","lat":35.7647018433,"lon":140.386001587,"cca2":"JP","region":"Asia Pacific","city":"Tokyo"},{"iata":"AMM","lat":31.7226009369,"lon":35.9931983948,"cca2":"JO","region":"Middle East","city":"Amman"},{"iata":"ALA","lat":43.3521003723,"lon":77.0404968262,"cca2":"KZ","region":"Asia Pacific","city":"Almaty"},{"iata":"MBA","lat":-4.0348300934,"lon":39.5942001343,"cca2":"KE","region":"Africa","city":"Mombasa"},{"iata":"NBO","lat":-1.319239974,"lon":36.9277992249,"cca2":"KE","region":"Africa","city":"Nairobi"},{"iata":"ICN","lat":37.4691009521,"lon":126.450996399,"cca2":"KR","region":"Asia Pacific","city":"Seoul"},{"iata":"KWI","lat":29.226600647,"lon":47.9688987732,"cca2":"KW","region":"Middle East","city":"Kuwait City"},{"iata":"VTE","lat":17.9757,"lon":102.5683,"cca2":"LA","region":"Asia Pacific","city":"Vientiane"},{"iata":"RIX","lat":56.9235992432,"lon":23.9710998535,"cca2":"LV","region":"Europe","city":"Riga"},{"iata":"BEY","lat":33.8208999634,"lon":35.4883995056,"cca2":"LB","region":"Middle East","city":"Beirut"},{"iata":"VNO","lat":54.6341018677,"lon":25.2858009338,"cca2":"LT","region":"Europe","city":"Vilnius"},{"iata":"LUX","lat":49.6265983582,"lon":6.211520195,"cca2":"LU","region":"Europe","city":"Luxembourg City"},{"iata":"MFM","lat":22.1495990753,"lon":113.592002869,"cca2":"MO","region":"Asia Pacific","city":"Macau"},{"iata":"TNR","lat":-18.91368,"lon":47.53613,"cca2":"MG","region":"Africa","city":"Antananarivo"},{"iata":"JHB","lat":1.635848,"lon":103.665943,"cca2":"MY","region":"Asia Pacific","city":"Johor Bahru"},{"iata":"KUL","lat":2.745579958,"lon":101.709999084,"cca2":"MY","region":"Asia Pacific","city":"Kuala Lumpur"},{"iata":"MLE","lat":4.1748,"lon":73.50888,"cca2":"MV","region":"Asia Pacific","city":"Male"},{"iata":"MRU","lat":-20.4302005768,"lon":57.6836013794,"cca2":"MU","region":"Africa","city":"Port Louis"},{"iata":"GDL","lat":20.5217990875,"lon":-103.3109970093,"cca2":"MX","region":"North America","city":"Guadalajara"},{"iata":"MEX","lat":19.4363002777,"lon":-99.0720977783,"cca2":"MX","region":"North America","city":"Mexico City"},{"iata":"QRO","lat":20.6173000336,"lon":-100.185997009,"cca2":"MX","region":"North America","city":"Queretaro"},{"iata":"KIV","lat":46.9277000427,"lon":28.9309997559,"cca2":"MD","region":"Europe","city":"Chișinău"},{"iata":"ULN","lat":47.8431015015,"lon":106.766998291,"cca2":"MN","region":"Asia Pacific","city":"Ulaanbaatar"},{"iata":"CMN","lat":33.3675003052,"lon":-7.5899701118,"cca2":"MA","region":"Africa","city":"Casablanca"},{"iata":"MPM","lat":-25.9207992554,"lon":32.5726013184,"cca2":"MZ","region":"Africa","city":"Maputo"},{"iata":"MDL","lat":21.7051697,"lon":95.9695206,"cca2":"MM","region":"Asia Pacific","city":"Mandalay"},{"iata":"RGN","lat":16.9073009491,"lon":96.1332015991,"cca2":"MM","region":"Asia Pacific","city":"Yangon"},{"iata":"KTM","lat":27.6965999603,"lon":85.3591003418,"cca2":"NP","region":"Asia Pacific","city":"Kathmandu"},{"iata":"AMS","lat":52.3086013794,"lon":4.7638897896,"cca2":"NL","region":"Europe","city":"Amsterdam"},{"iata":"NOU","lat":-22.0146007538,"lon":166.212997436,"cca2":"NC","region":"Oceania","city":"Noumea"},{"iata":"AKL","lat":-37.0080986023,"lon":174.792007446,"cca2":"NZ","region":"Oceania","city":"Auckland"},{"iata":"CHC","lat":-43.4893989563,"lon":172.5319976807,"cca2":"NZ","region":"Oceania","city":"Christchurch"},{"iata":"LOS","lat":6.5773701668,"lon":3.321160078,"cca2":"NG","region":"Africa","city":"Lagos"},{"iata":"OSL","lat":60.193901062,"lon":11.100399971,"cca2":"NO","region":"Europe","city":"Oslo"},{"iata":"MCT","lat":23.5932998657,"lon":58.2844009399,"cca2":"OM","region":"Middle East","city":"Muscat"},{"iata":"ISB","lat":33.6166992188,"lon":73.0991973877,"cca2":"PK","region":"Asia Pacific","city":"Islamabad"},{"iata":"KHI","lat":24.9064998627,"lon":67.1607971191,"cca2":"PK","region":"Asia Pacific","city":"Karachi"},{"iata":"LHE","lat":31.5216007233,"lon":74.4036026001,"cca2":"PK","region":"Asia Pacific","city":"Lahore"},{"iata":"ZDM","lat":32.2719,"lon":35.0194,"cca2":"PS","region":"Middle East","city":"Ramallah"},{"iata":"PTY","lat":9.0713596344,"lon":-79.3834991455,"cca2":"PA","region":"South America","city":"Panama City"},{"iata":"ASU","lat":-25.2399997711,"lon":-57.5200004578,"cca2":"PY","region":"South America","city":"Asunción"},{"iata":"LIM","lat":-12.021900177,"lon":-77.1143035889,"cca2":"PE","region":"South America","city":"Lima"},{"iata":"CGY","lat":8.4156198502,"lon":124.611000061,"cca2":"PH","region":"Asia Pacific","city":"Cagayan de Oro"},{"iata":"CEB","lat":10.3074998856,"lon":123.978996277,"cca2":"PH","region":"Asia Pacific","city":"Cebu"},{"iata":"MNL","lat":14.508600235,"lon":121.019996643,"cca2":"PH","region":"Asia Pacific","city":"Manila"},{"iata":"WAW","lat":52.1656990051,"lon":20.9671001434,"cca2":"PL","region":"Europe","city":"Warsaw"},{"iata":"LIS","lat":38.7812995911,"lon":-9.1359195709,"cca2":"PT","region":"Europe","city":"Lisbon"},{"iata":"SJU","lat":18.411391,"lon":-66.102793,"cca2":"PR","region":"North America","city":"San Juan"},{"iata":"DOH","lat":25.2605946,"lon":51.6137665,"cca2":"QA","region":"Middle East","city":"Doha"},{"iata":"RUN","lat":-20.8871002197,"lon":55.5102996826,"cca2":"RE","region":"Africa","city":"Saint-Denis"},{"iata":"OTP","lat":44.5722007751,"lon":26.1021995544,"cca2":"RO","region":"Europe","city":"Bucharest"},{"iata":"KJA","lat":56.0153,"lon":92.8932,"cca2":"RU","region":"Asia Pacific","city":"Krasnoyarsk"},{"iata":"DME","lat":55.4087982178,"lon":37.9062995911,"cca2":"RU","region":"Europe","city":"Moscow"},{"iata":"LED","lat":59.8003005981,"lon":30.2625007629,"cca2":"RU","region":"Europe","city":"Saint Petersburg"},{"iata":"KLD","lat":56.8587,"lon":35.9176,"cca2":"RU","region":"Europe","city":"Tver"},{"iata":"SVX","lat":56.8431,"lon":60.6454,"cca2":"RU","region":"Asia Pacific","city":"Yekaterinburg"},{"iata":"KGL","lat":-1.9686299563,"lon":30.1394996643,"cca2":"RW","region":"Africa","city":"Kigali"},{"iata":"DMM","lat":26.471200943,"lon":49.7979011536,"cca2":"SA","region":"Middle East","city":"Dammam"},{"iata":"JED","lat":21.679599762,"lon":39.15650177,"cca2":"SA","region":"Middle East","city":"Jeddah"},{"iata":"RUH","lat":24.9575996399,"lon":46.6987991333,"cca2":"SA","region":"Middle East","city":"Riyadh"},{"iata":"DKR","lat":14.7412099,"lon":-17.4889771,"cca2":"SN","region":"Africa","city":"Dakar"},{"iata":"BEG","lat":44.8184013367,"lon":20.3090991974,"cca2":"RS","region":"Europe","city":"Belgrade"},{"iata":"SIN","lat":1.3501900434,"lon":103.994003296,"cca2":"SG","region":"Asia Pacific","city":"Singapore"},{"iata":"BTS","lat":48.1486,"lon":17.1077,"cca2":"SK","region":"Europe","city":"Bratislava"},{"iata":"CPT","lat":-33.9648017883,"lon":18.6016998291,"cca2":"ZA","region":"Africa","city":"Cape Town"},{"iata":"DUR","lat":-29.6144444444,"lon":31.1197222222,"cca2":"ZA","region":"Africa","city":"Durban"},{"iata":"JNB","lat":-26.133333,"lon":28.25,"cca2":"ZA","region":"Africa","city":"Johannesburg"},{"iata":"BCN","lat":41.2971000671,"lon":2.0784599781,"cca2":"ES","region":"Europe","city":"Barcelona"},{"iata":"MAD","lat":40.4936,"lon":-3.56676,"cca2":"ES","region":"Europe","city":"Madrid"},{"iata":"CMB","lat":7.1807599068,"lon":79.8841018677,"cca2":"LK","region":"Asia Pacific","city":"Colombo"},{"iata":"PBM","lat":5.452831,"lon":-55.187783,"cca2":"SR","region":"South America","city":"Paramaribo"},{"iata":"GOT","lat":57.6627998352,"lon":12.279800415,"cca2":"SE","region":"Europe","city":"Gothenburg"},{"iata":"ARN","lat":59.6519012451,"lon":17.9186000824,"cca2":"SE","region":"Europe","city":"Stockholm"},{"iata":"GVA","lat":46.2380981445,"lon":6.1089501381,"cca2":"CH","region":"Europe","city":"Geneva"},{"iata":"ZRH","lat":47.4646987915,"lon":8.5491695404,"cca2":"CH","region":"Europe","city":"Zurich"},{"iata":"KHH","lat":22.5771007538,"lon":120.3499984741,"cca2":"TW","region":"Asia Pacific","city":"Kaohsiung City"},{"iata":"TPE","lat":25.0776996613,"lon":121.233001709,"cca2":"TW","region":"Asia Pacific","city":"Taipei"},{"iata":"DAR","lat":-6.8781099319,"lon":39.2025985718,"cca2":"TZ","region":"Africa","city":"Dar es Salaam"},{"iata":"BKK","lat":13.6810998917,"lon":100.747001648,"cca2":"TH","region":"Asia Pacific","city":"Bangkok"},{"iata":"CNX","lat":18.7667999268,"lon":98.962600708,"cca2":"TH","region":"Asia Pacific","city":"Chiang Mai"},{"iata":"URT","lat":9.1325998306,"lon":99.135597229,"cca2":"TH","region":"Asia Pacific","city":"Surat Thani"},{"iata":"TUN","lat":36.8510017395,"lon":10.2271995544,"cca2":"TN","region":"Africa","city":"Tunis"},{"iata":"IST","lat":40.9768981934,"lon":28.8145999908,"cca2":"TR","region":"Europe","city":"Istanbul"},{"iata":"ADB","lat":38.32377,"lon":27.14317,"cca2":"TR","region":"Europe","city":"Izmir"},{"iata":"KBP","lat":50.3450012207,"lon":30.8946990967,"cca2":"UA","region":"Europe","city":"Kyiv"},{"iata":"DXB","lat":25.2527999878,"lon":55.3643989563,"cca2":"AE","region":"Middle East","city":"Dubai"},{"iata":"EDI","lat":55.9500007629,"lon":-3.3724999428,"cca2":"GB","region":"Europe","city":"Edinburgh"},{"iata":"LHR","lat":51.4706001282,"lon":-0.4619410038,"cca2":"GB","region":"Europe","city":"London"},{"iata":"MAN","lat":53.3536987305,"lon":-2.2749500275,"cca2":"GB","region":"Europe","city":"Manchester"},{"iata":"MGM","lat":32.30059814,"lon":-86.39399719,"cca2":"US","region":"North America","city":"Montgomery"},{"iata":"ANC","lat":61.158555,"lon":-149.890208,"cca2":"US","region":"North America","city":"Anchorage"},{"iata":"PHX","lat":33.434299469,"lon":-112.012001038,"cca2":"US","region":"North America","city":"Phoenix"},{"iata":"LAX","lat":33.94250107,"lon":-118.4079971,"cca2":"US","region":"North America","city":"Los Angeles"},{"iata":"SMF","lat":38.695400238,"lon":-121.591003418,"cca2":"US","region":"North America","city":"Sacramento"},{"iata":"SAN","lat":32.7336006165,"lon":-117.190002441,"cca2":"US","region":"North America","city":"San Diego"},{"iata":"SFO","lat":37.6189994812,"lon":-122.375,"cca2":"US","region":"North America","city":"San Francisco"},{"iata":"SJC","lat":37.3625984192,"lon":-121.929000855,"cca2":"US","region":"North America","city":"San Jose"},{"iata":"DEN","lat":39.8616981506,"lon":-104.672996521,"cca2":"US","region":"North America","city":"Denver"},{"iata":"JAX","lat":30.4941005707,"lon":-81.6878967285,"cca2":"US","region":"North America","city":"Jacksonville"},{"iata":"MIA","lat":25.7931995392,"lon":-80.2906036377,"cca2":"US","region":"North America","city":"Miami"},{"iata":"TLH","lat":30.3964996338,"lon":-84.3503036499,"cca2":"US","region":"North America","city":"Tallahassee"},{"iata":"TPA","lat":27.9755001068,"lon":-82.533203125,"cca2":"US","region":"North America","city":"Tampa"},{"iata":"ATL","lat":33.6366996765,"lon":-84.4281005859,"cca2":"US","region":"North America","city":"Atlanta"},{"iata":"HNL","lat":21.3187007904,"lon":-157.9219970703,"cca2":"US","region":"North America","city":"Honolulu"},{"iata":"ORD","lat":41.97859955,"lon":-87.90480042,"cca2":"US","region":"North America","city":"Chicago"},{"iata":"IND","lat":39.717300415,"lon":-86.2944030762,"cca2":"US","region":"North America","city":"Indianapolis"},{"iata":"BGR","lat":44.8081,"lon":-68.795,"cca2":"US","region":"North America","city":"Bangor"},{"iata":"BOS","lat":42.36429977,"lon":-71.00520325,"cca2":"US","region":"North America","city":"Boston"},{"iata":"DTW","lat":42.2123985291,"lon":-83.3534011841,"cca2":"US","region":"North America","city":"Detroit"},{"iata":"MSP","lat":44.8819999695,"lon":-93.2218017578,"cca2":"US","region":"North America","city":"Minneapolis"},{"iata":"MCI","lat":39.2975997925,"lon":-94.7138977051,"cca2":"US","region":"North America","city":"Kansas City"},{"iata":"STL","lat":38.7486991882,"lon":-90.3700027466,"cca2":"US","region":"North America","city":"St. Louis"},{"iata":"OMA","lat":41.3031997681,"lon":-95.8940963745,"cca2":"US","region":"North America","city":"Omaha"},{"iata":"LAS","lat":36.08010101,"lon":-115.1520004,"cca2":"US","region":"North America","city":"Las Vegas"},{"iata":"EWR","lat":40.6925010681,"lon":-74.1687011719,"cca2":"US","region":"North America","city":"Newark"},{"iata":"ABQ","lat":35.0844,"lon":-106.6504,"cca2":"US","region":"North America","city":"Albuquerque"},{"iata":"BUF","lat":42.94049835,"lon":-78.73220062,"cca2":"US","region":"North America","city":"Buffalo"},{"iata":"CLT","lat":35.2140007019,"lon":-80.9430999756,"cca2":"US","region":"North America","city":"Charlotte"},{"iata":"RDU","lat":35.93543,"lon":-78.88075,"cca2":"US","region":"North America","city":"Durham"},{"iata":"CLE","lat":41.50069,"lon":-81.68412,"cca2":"US","region":"North America","city":"Cleveland"},{"iata":"CMH","lat":39.9980010986,"lon":-82.8918991089,"cca2":"US","region":"North America","city":"Columbus"},{"iata":"OKC","lat":35.46655,"lon":-97.65373,"cca2":"US","region":"North America","city":"Oklahoma City"},{"iata":"PDX","lat":45.58869934,"lon":-122.5979996,"cca2":"US","region":"North America","city":"Portland"},{"iata":"PHL","lat":39.8718986511,"lon":-75.2410964966,"cca2":"US","region":"North America","city":"Philadelphia"},{"iata":"PIT","lat":40.49150085,"lon":-80.23290253,"cca2":"US","region":"North America","city":"Pittsburgh"},{"iata":"FSD","lat":43.540819819502,"lon":-96.65511577730963,"cca2":"US","region":"North America","city":"Sioux Falls"},{"iata":"MEM","lat":35.0424003601,"lon":-89.9766998291,"cca2":"US","region":"North America","city":"Memphis"},{"iata":"BNA","lat":36.1245002747,"lon":-86.6781997681,"cca2":"US","region":"North America","city":"Nashville"},{"iata":"AUS","lat":30.1975,"lon":-97.6664,"cca2":"US","region":"North America","city":"Austin"},{"iata":"DFW","lat":32.8968009949,"lon":-97.0380020142,"cca2":"US","region":"North America","city":"Dallas"},{"iata":"IAH","lat":29.9843997955,"lon":-95.3414001465,"cca2":"US","region":"North America","city":"Houston"},{"iata":"MFE","lat":26.17580032,"lon":-98.23860168,"cca2":"US","region":"North America","city":"McAllen"},{"iata":"SAT","lat":29.429461,"lon":-98.487061,"cca2":"US","region":"North America","city":"San Antonio"},{"iata":"SLC","lat":40.7883987427,"lon":-111.977996826,"cca2":"US","region":"North America","city":"Salt Lake City"},{"iata":"IAD","lat":38.94449997,"lon":-77.45580292,"cca2":"US","region":"North America","city":"Ashburn"},{"iata":"ORF","lat":36.8945999146,"lon":-76.2012023926,"cca2":"US","region":"North America","city":"Norfolk"},{"iata":"RIC","lat":37.5051994324,"lon":-77.3197021484,"cca2":"US","region":"North America","city":"Richmond"},{"iata":"SEA","lat":47.4490013123,"lon":-122.308998108,"cca2":"US","region":"North America","city":"Seattle"},{"iata":"TAS","lat":41.257900238,"lon":69.2811965942,"cca2":"UZ","region":"Asia Pacific","city":"Tashkent"},{"iata":"HAN","lat":21.221200943,"lon":105.806999206,"cca2":"VN","region":"Asia Pacific","city":"Hanoi"},{"iata":"SGN","lat":10.8187999725,"lon":106.652000427,"cca2":"VN","region":"Asia Pacific","city":"Ho Chi Minh City"},{"iata":"HRE","lat":-17.9318008423,"lon":31.0928001404,"cca2":"ZW","region":"Africa","city":"Harare"}]`
-	json.Unmarshal([]byte(body), &locations)
+	locations := st.loadLocations()
 	locationMap := make(map[string]Location)
 	for _, loc := range locations {
 		locationMap[loc.Iata] = loc
@@ -145,56 +299,158 @@ func (st *CFSpeedTest) GetLocationMap() map[string]Location {
 	return locationMap
 }
 
-// inc函数实现ip地址自增
-func incIP(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
+// 从文件中读取IP地址，CIDR在测试时才逐个展开。文件名为-时从标准输入读取，设置了Prefixes时使用Prefixes
+func (st *CFSpeedTest) readIPs(File string) (IpIterator, error) {
+	if len(st.Prefixes) > 0 || File == stdinFile {
+		content, err := st.ipContent()
+		if err != nil {
+			return nil, err
 		}
+		return st.ParseIPs(bytes.NewReader(content))
 	}
-}
-
-// 从文件中读取IP地址
-func (st *CFSpeedTest) readIPs(File string) ([]IpPair, error) {
 	file, err := os.Open(File)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
-	var ips []IpPair
-	scanner := bufio.NewScanner(file)
+	return st.ParseIPs(file)
+}
+
+// ipContent 返回IP列表的内容，标准输入读取后缓存，计算进度文件的摘要和生成IP时使用相同的内容
+func (st *CFSpeedTest) ipContent() ([]byte, error) {
+	if len(st.Prefixes) > 0 {
+		var buf bytes.Buffer
+		for _, prefix := range st.Prefixes {
+			buf.WriteString(prefix.String())
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), nil
+	}
+	if st.IpFile != stdinFile {
+		return os.ReadFile(st.IpFile)
+	}
+	if st.stdinData == nil {
+		stdin := st.Stdin
+		if stdin == nil {
+			stdin = os.Stdin
+		}
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, err
+		}
+		st.stdinData = data
+	}
+	return st.stdinData, nil
+}
+
+// ParseIPs 读取IP列表，每行格式与IP文件相同，CIDR在测试时才逐个展开，重复的IP只测试一次。
+// Seed为0时随机生成种子
+func (st *CFSpeedTest) ParseIPs(reader io.Reader) (IpIterator, error) {
+	if st.Seed == 0 {
+		st.Seed = time.Now().UnixNano()
+	}
+	var ranges []ipRange
+	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
-		ipAddr := scanner.Text()
-		ip := ipAddr
-		port := st.DefaultPort
-		// 指定端口
-		if strings.Contains(ipAddr, ",") {
-			arr := strings.Split(ipAddr, ",")
-			ip = arr[0]
-			port, _ = strconv.Atoi(arr[1])
-		}
-		// 判断是否为 CIDR 格式的 IP 地址
-		if strings.Contains(ip, "/") {
-			ipr, ipNet, err := net.ParseCIDR(ip)
-			if err != nil {
-				fmt.Printf("无法解析CIDR格式的IP: %v\n", err)
-				continue
-			}
-			for ipn := ipr.Mask(ipNet.Mask); ipNet.Contains(ipn); incIP(ipn) {
-				ips = append(ips, IpPair{ip: ipn.String(), port: port})
-			}
-		} else {
-			ips = append(ips, IpPair{ip: ip, port: port})
+		r, err := st.parseIPLine(scanner.Text())
+		if err != nil {
+			st.printf("无法解析IP: %v\n", err)
+			continue
 		}
+		if r != nil {
+			ranges = append(ranges, *r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
-	return ips, scanner.Err()
+	ranges, dup := dedupeRanges(ranges)
+	if dup > 0 {
+		st.printf("%d行IP与前面的行重复，重复的IP只测试一次\n", dup)
+	}
+	if st.ExcludeFile != "" {
+		exclude, err := st.loadExclude()
+		if err != nil {
+			return nil, fmt.Errorf("无法读取排除的IP段文件: %w", err)
+		}
+		var excluded int64
+		ranges, excluded = excludeRanges(ranges, exclude)
+		if excluded < 0 {
+			st.printf("已排除%s中的IP，数量过多无法统计\n", st.ExcludeFile)
+		} else if excluded > 0 {
+			st.printf("已排除%s中的%d个IP\n", st.ExcludeFile, excluded)
+		}
+	}
+	return newRangeIterator(ranges, rand.New(rand.NewSource(st.Seed))), nil
+}
+
+// parseIPLine 解析一行IP，格式为IP[/前缀][,端口][,sample=抽样个数][,prefix=抽样子网前缀]，空行和#开头的注释返回nil
+func (st *CFSpeedTest) parseIPLine(line string) (*ipRange, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	arr := strings.Split(line, ",")
+	ip := arr[0]
+	r := &ipRange{port: st.DefaultPort, sample: st.SampleCount}
+	// 指定端口
+	if len(arr) > 1 && arr[1] != "" {
+		r.port, _ = strconv.Atoi(arr[1])
+	}
+	samplePrefix := -1
+	for _, opt := range arr[min(len(arr), 2):] {
+		key, value, _ := strings.Cut(opt, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s 参数 %s 不正确", line, opt)
+		}
+		switch key {
+		case "sample":
+			r.sample = n
+		case "prefix":
+			samplePrefix = n
+		default:
+			return nil, fmt.Errorf("%s 不支持参数 %s", line, key)
+		}
+	}
+
+	// 判断是否为 CIDR 格式的 IP 地址
+	if strings.Contains(ip, "/") {
+		prefix, err := netip.ParsePrefix(ip)
+		if err != nil {
+			return nil, err
+		}
+		r.prefix = prefix
+	} else if addr, err := netip.ParseAddr(ip); err == nil {
+		r.prefix = netip.PrefixFrom(addr, addr.BitLen())
+	} else {
+		r.host = ip
+		return r, nil
+	}
+
+	if samplePrefix >= 0 {
+		r.samplePrefix = samplePrefix
+	} else if r.prefix.Addr().Is4() {
+		r.samplePrefix = st.SamplePrefix
+	} else {
+		r.samplePrefix = st.SamplePrefix6
+	}
+	return r, nil
 }
 
 func (st *CFSpeedTest) Output(results []*SpeedTestResult) {
+	switch st.OutputFormat {
+	case "json":
+		st.outputJSON(results)
+		return
+	case "ndjson":
+		st.outputNDJSON(results)
+		return
+	}
+
 	file, err := os.Create(st.OutFile)
 	if err != nil {
-		fmt.Printf("无法创建文件: %v\n", err)
+		st.printf("无法创建文件: %v\n", err)
 		return
 	}
 	defer file.Close()
@@ -202,20 +458,40 @@ func (st *CFSpeedTest) Output(results []*SpeedTestResult) {
 	// 写入UTF-8 BOM，避免乱码
 	file.WriteString("\xEF\xBB\xBF")
 	writer := csv.NewWriter(file)
+	header := []string{"IP地址", "端口", "TLS", "数据中心", "地区", "城市", "网络延迟(毫秒)", "最小延迟(毫秒)", "延迟中位数(毫秒)", "95分位延迟(毫秒)", "抖动(毫秒)", "丢包率(%)", "综合评分"}
+	if st.NearPoint != nil {
+		header = append(header, "距离(km)")
+	}
 	if st.SpeedTestThread > 0 {
-		writer.Write([]string{"IP地址", "端口", "TLS", "数据中心", "地区", "城市", "网络延迟(毫秒)", "下载速度(MB/s)"})
-	} else {
-		writer.Write([]string{"IP地址", "端口", "TLS", "数据中心", "地区", "城市", "网络延迟(毫秒)"})
+		header = append(header, "下载速度(MB/s)", "峰值速度(MB/s)", "持续速度(MB/s)", "稳定性", "测速协议")
+		if st.ParallelConns > 1 {
+			header = append(header, fmt.Sprintf("%d并发下载速度(MB/s)", st.ParallelConns))
+		}
+		if st.UploadTestURL != "" {
+			header = append(header, "上传速度(MB/s)")
+		}
 	}
+	writer.Write(header)
 	if len(results) == 0 {
-		fmt.Println("没有找到符合的数据")
+		st.println("没有找到符合的数据")
 	}
 	for _, res := range results {
+		row := []string{res.Result.IP, strconv.Itoa(res.Result.Port), strconv.FormatBool(st.EnableTLS), res.Result.DataCenter, res.Result.Region, res.Result.City, res.Result.Latency,
+			formatMs(res.MinDelay), formatMs(res.MedianDelay), formatMs(res.P95Delay), formatMs(res.Jitter), fmt.Sprintf("%.2f", res.Loss), fmt.Sprintf("%.2f", res.Score)}
+		if st.NearPoint != nil {
+			row = append(row, fmt.Sprintf("%.0f", res.Distance))
+		}
 		if st.SpeedTestThread > 0 {
-			writer.Write([]string{res.Result.ip, strconv.Itoa(res.Result.port), strconv.FormatBool(st.EnableTLS), res.Result.dataCenter, res.Result.region, res.Result.city, res.Result.latency, fmt.Sprintf("%.2f", res.downloadSpeed)})
-		} else {
-			writer.Write([]string{res.Result.ip, strconv.Itoa(res.Result.port), strconv.FormatBool(st.EnableTLS), res.Result.dataCenter, res.Result.region, res.Result.city, res.Result.latency})
+			row = append(row, fmt.Sprintf("%.2f", res.DownloadSpeed), fmt.Sprintf("%.2f", res.Throughput.getPeak()),
+				fmt.Sprintf("%.2f", res.Throughput.getSustained()), fmt.Sprintf("%.2f", res.Throughput.getStability()), res.Protocol)
+			if st.ParallelConns > 1 {
+				row = append(row, fmt.Sprintf("%.2f", res.ParallelSpeed))
+			}
+			if st.UploadTestURL != "" {
+				row = append(row, fmt.Sprintf("%.2f", res.UploadSpeed))
+			}
 		}
+		writer.Write(row)
 	}
 
 	writer.Flush()
@@ -0,0 +1,69 @@
+package speed
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Tester 以库的方式使用测速功能，不输出到标准输出，也不写入文件
+type Tester struct {
+	config CFSpeedTest
+}
+
+// NewTester 创建Tester，配置的含义与命令行参数相同，IpFile、OutFile、OutputFormat、Shuffle不生效。
+// Seed为0时随机生成，之后每次Run使用相同的种子
+func NewTester(config CFSpeedTest) *Tester {
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+	return &Tester{config: config}
+}
+
+// ParseIPs 与CFSpeedTest.ParseIPs相同，使用Tester的配置和随机种子，不输出到标准输出
+func (t *Tester) ParseIPs(reader io.Reader) (IpIterator, error) {
+	st := t.config
+	st.Stdout = io.Discard
+	return st.ParseIPs(reader)
+}
+
+// Run 测试targets中的IP，每个IP测试完成时调用fn（可以为nil，不会并发调用），返回排序后的全部结果。
+// ctx取消后会中断正在进行的测试，返回已完成的结果和ctx的错误，配置不正确时不测试直接返回错误
+func (t *Tester) Run(ctx context.Context, targets IpIterator, fn func(*SpeedTestResult)) ([]*SpeedTestResult, error) {
+	st := t.config
+	st.Stdout = io.Discard
+	st.OutputFormat = ""
+	st.ndjson = nil
+	if fn != nil {
+		var mu sync.Mutex
+		st.onResult = func(res *SpeedTestResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			fn(res)
+		}
+	}
+	if err := st.prepare(); err != nil {
+		return nil, err
+	}
+
+	resultChan := st.TestDelay(ctx, targets)
+	results := st.TestDownload(ctx, resultChan)
+	results = st.SearchNeighbours(ctx, results)
+	return results, ctx.Err()
+}
+
+// Stream 与Run相同，通过channel返回每个IP的结果，测试结束或配置不正确时关闭channel
+func (t *Tester) Stream(ctx context.Context, targets IpIterator) <-chan *SpeedTestResult {
+	ch := make(chan *SpeedTestResult)
+	go func() {
+		defer close(ch)
+		t.Run(ctx, targets, func(res *SpeedTestResult) {
+			select {
+			case ch <- res:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return ch
+}
@@ -0,0 +1,69 @@
+package speed
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jackrun123/cfiptest/pkgs/edgetest"
+)
+
+func TestTesterStream(t *testing.T) {
+	hkg := newEdge(t, edgetest.Config{Colo: "HKG"})
+	nrt := newEdge(t, edgetest.Config{Colo: "NRT"})
+	tester := NewTester(CFSpeedTest{
+		EnableTLS:         true,
+		DelayTestURL:      "edge.test",
+		SpeedTestURL:      "edge.test/__down?bytes=1000000",
+		SpeedTestTimeout:  5,
+		SpeedTestThread:   1,
+		MaxSpeedTestCount: 10,
+		MaxThread:         4,
+	})
+
+	targets := NewSliceIterator([]IpPair{{IP: hkg.IP, Port: hkg.Port}, {IP: nrt.IP, Port: nrt.Port}})
+	colos := map[string]bool{}
+	for res := range tester.Stream(context.Background(), targets) {
+		if res.DownloadSpeed <= 0 {
+			t.Errorf("%s download speed = %.2f", res.DataCenter, res.DownloadSpeed)
+		}
+		colos[res.DataCenter] = true
+	}
+	if !colos["HKG"] || !colos["NRT"] || len(colos) != 2 {
+		t.Errorf("got colos %v, want HKG and NRT", colos)
+	}
+}
+
+func TestTesterRunCancelled(t *testing.T) {
+	edge := newEdge(t, edgetest.Config{})
+	tester := NewTester(CFSpeedTest{EnableTLS: true, DelayTestURL: "edge.test", MaxThread: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err := tester.Run(ctx, NewSliceIterator([]IpPair{{IP: edge.IP, Port: edge.Port}}), nil)
+	if err != context.Canceled || len(results) != 0 {
+		t.Errorf("Run() = %v, %v, want no results and context.Canceled", results, err)
+	}
+}
+
+func TestTesterParseIPsSeed(t *testing.T) {
+	tester := NewTester(CFSpeedTest{})
+	if tester.config.Seed == 0 {
+		t.Fatal("seed not initialised")
+	}
+	// 同一个Tester多次解析时抽样结果相同
+	var lists [2][]IpPair
+	for i := range lists {
+		targets, err := tester.ParseIPs(strings.NewReader("10.0.0.0/16,443,sample=2,prefix=24"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ip, ok := targets.Next(); ok; ip, ok = targets.Next() {
+			lists[i] = append(lists[i], ip)
+		}
+	}
+	if len(lists[0]) != 512 || !slices.Equal(lists[0], lists[1]) {
+		t.Errorf("got %d and %d IPs, want the same 512", len(lists[0]), len(lists[1]))
+	}
+}
@@ -0,0 +1,155 @@
+package speed
+
+import (
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSampleInterval = 250 * time.Millisecond // 默认吞吐量采样间隔
+	slowStartDuration     = time.Second            // 计算持续速度时忽略的TCP慢启动时长
+	earlyCheckDuration    = 2 * time.Second        // 速度过低时提前结束的检测时间
+)
+
+// Throughput 一次下载测速的吞吐量统计，速度单位均为MB/s
+type Throughput struct {
+	Speed     float64   // 平均速度
+	Samples   []float64 // 每个采样周期的速度
+	Interval  time.Duration
+	Peak      float64 // 峰值速度
+	Sustained float64 // 忽略慢启动阶段后的平均速度
+	Stability float64 // 稳定性评分，0~1，越大越稳定
+}
+
+func (t *Throughput) getPeak() float64 {
+	if t == nil {
+		return 0
+	}
+	return t.Peak
+}
+
+func (t *Throughput) getSustained() float64 {
+	if t == nil {
+		return 0
+	}
+	return t.Sustained
+}
+
+func (t *Throughput) getStability() float64 {
+	if t == nil {
+		return 0
+	}
+	return t.Stability
+}
+
+// StableSpeed 持续速度乘以稳定性，先快后慢的IP会排在速度平稳的IP后面
+func (t *Throughput) StableSpeed() float64 {
+	if t == nil {
+		return 0
+	}
+	return t.Sustained * t.Stability
+}
+
+// calculate 根据采样计算峰值、持续速度和稳定性，稳定性为1/(1+变异系数)
+func (t *Throughput) calculate() {
+	if len(t.Samples) == 0 {
+		t.Peak = t.Speed
+		t.Sustained = t.Speed
+		t.Stability = 1
+		return
+	}
+	for _, s := range t.Samples {
+		t.Peak = max(t.Peak, s)
+	}
+
+	// 忽略慢启动阶段，但至少保留一半的采样
+	skip := int(slowStartDuration / t.Interval)
+	skip = min(skip, len(t.Samples)/2)
+	steady := t.Samples[skip:]
+
+	var sum float64
+	for _, s := range steady {
+		sum += s
+	}
+	t.Sustained = sum / float64(len(steady))
+	if t.Sustained <= 0 {
+		return
+	}
+
+	var variance float64
+	for _, s := range steady {
+		variance += (s - t.Sustained) * (s - t.Sustained)
+	}
+	cv := math.Sqrt(variance/float64(len(steady))) / t.Sustained
+	t.Stability = 1 / (1 + cv)
+}
+
+// readSpeed 读取响应体直到结束或超时，按SampleInterval记录每个周期的速度，速度低于minSpeed时提前结束
+func (st *CFSpeedTest) readSpeed(body io.Reader, startTime time.Time, minSpeed float64) *Throughput {
+	interval := time.Duration(st.SampleInterval) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultSampleInterval
+	}
+	t := &Throughput{Interval: interval}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	var written atomic.Int64
+
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var last int64
+		checked := st.SpeedTestTimeout <= 2
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				current := written.Load()
+				t.Samples = append(t.Samples, float64(current-last)/interval.Seconds()/1024/1024)
+				last = current
+
+				// 中途检测下载速度
+				elapsed := time.Since(startTime)
+				if !checked && elapsed >= earlyCheckDuration {
+					checked = true
+					speed := float64(current) / elapsed.Seconds() / 1024 / 1024
+					if speed < 0.7*minSpeed {
+						close(stop)
+					}
+				}
+			}
+		}
+	}()
+
+	// 复制响应体到/dev/null，并计算下载速度
+	buf := make([]byte, 8024)
+
+outerLoop:
+	for {
+		select {
+		case <-stop:
+			break outerLoop
+		default:
+			n, err := body.Read(buf)
+			if n > 0 {
+				written.Add(int64(n))
+			}
+			if err != nil {
+				break outerLoop
+			}
+		}
+	}
+	close(done)
+	<-sampled
+
+	duration := time.Since(startTime)
+	t.Speed = float64(written.Load()) / duration.Seconds() / 1024 / 1024
+	t.calculate()
+	return t
+}
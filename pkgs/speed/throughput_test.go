@@ -0,0 +1,30 @@
+package speed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThroughputCalculate(t *testing.T) {
+	steady := &Throughput{Speed: 5, Interval: 250 * time.Millisecond, Samples: []float64{1, 3, 5, 5, 5, 5, 5, 5, 5, 5}}
+	steady.calculate()
+	if steady.Peak != 5 || steady.Sustained != 5 || steady.Stability != 1 {
+		t.Errorf("steady peak = %.2f, sustained = %.2f, stability = %.2f", steady.Peak, steady.Sustained, steady.Stability)
+	}
+
+	collapse := &Throughput{Speed: 5.5, Interval: 250 * time.Millisecond, Samples: []float64{10, 10, 10, 10, 10, 10, 1, 1, 1, 1, 1, 1}}
+	collapse.calculate()
+	if collapse.Peak != 10 || collapse.Stability >= 1 {
+		t.Errorf("collapse peak = %.2f, stability = %.2f", collapse.Peak, collapse.Stability)
+	}
+	if collapse.StableSpeed() >= steady.StableSpeed() {
+		t.Errorf("collapse stable speed %.2f should be below steady %.2f", collapse.StableSpeed(), steady.StableSpeed())
+	}
+
+	// 下载时间太短没有采样时使用平均速度
+	short := &Throughput{Speed: 3, Interval: 250 * time.Millisecond}
+	short.calculate()
+	if short.Peak != 3 || short.Sustained != 3 || short.Stability != 1 {
+		t.Errorf("short peak = %.2f, sustained = %.2f, stability = %.2f", short.Peak, short.Sustained, short.Stability)
+	}
+}
@@ -0,0 +1,81 @@
+package speed
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// uploadReader 生成指定大小的上传数据，并统计已被读取的字节数
+type uploadReader struct {
+	remaining int64
+	read      atomic.Int64
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	n := int64(len(p))
+	if n > r.remaining {
+		n = r.remaining
+	}
+	clear(p[:n])
+	r.remaining -= n
+	r.read.Add(n)
+	return int(n), nil
+}
+
+// getUploadSpeed 向UploadTestURL上传UploadSize MB数据，返回上传速度(MB/s)，超时时按已发送的数据计算
+func (st *CFSpeedTest) getUploadSpeed(ctx context.Context, ip string, port int) (float64, error) {
+	size := int64(st.UploadSize) * 1024 * 1024
+	body := &uploadReader{remaining: size}
+	req, _ := http.NewRequestWithContext(ctx, "POST", st.withProtocol(st.UploadTestURL), body)
+	req.ContentLength = size
+	req.Header.Set("User-Agent", UA)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Close = true
+
+	// 创建TCP连接
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 0,
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		return -1, err
+	}
+	defer conn.Close()
+
+	startTime := time.Now()
+	client := http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // 跳过证书验证
+			Dial: func(network, addr string) (net.Conn, error) {
+				return conn, nil
+			},
+		},
+		//设置单个IP测速最长时间
+		Timeout: time.Duration(st.SpeedTestTimeout) * time.Second,
+	}
+	resp, err := client.Do(req)
+	duration := time.Since(startTime)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return -1, fmt.Errorf("upload status: %s", resp.Status)
+		}
+	} else if body.read.Load() == 0 {
+		return -1, err
+	}
+
+	speed := float64(body.read.Load()) / duration.Seconds() / 1024 / 1024
+	return speed, nil
+}